@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shopwarelabs/discord-bot/handlers"
+	"github.com/shopwarelabs/discord-bot/models"
+)
+
+const usage = `Usage: discord-bot [-config file] <command> [arguments]
+
+Commands:
+  serve                         run the bot and web server (default)
+  users list [-all] [-json]     list verified users, -all includes revoked ones
+  users show <discord-id>       show everything stored about a user
+  users revoke <discord-id>     revoke a verification and remove its roles
+  users import [-dry-run] [-assign-roles] [-report file] <file.csv|file.json>
+                                import previously verified users with a per-row report
+  users export [-format csv|json|ndjson] [-status active|revoked|all] [-domain d]
+               [-from date] [-to date] [file]
+                                stream users to a file or stdout
+  audit export [-format csv|json|ndjson] [-type t] [-discord-id id]
+               [-from date] [-to date] [file]
+                                stream audit events to a file or stdout
+  migrate [status|up|down] [n]  show or change the schema version
+  backup [file]                 write an online backup of the SQLite database, by default
+                                into the backup directory with rotation
+  restore <file>                replace the database with a verified backup, stop the server first
+  integrity-check               run PRAGMA integrity_check on the database
+  reconcile [-dry-run]          sync the roles in every guild with the verification records
+  commands sync [-dry-run]      register the slash commands in every configured guild and
+                                remove retired ones, -dry-run only prints the differences
+  commands remove               delete the slash commands from every configured guild
+  encrypt-pii                   encrypt rows written before DATA_ENCRYPTION_KEY was set
+`
+
+// runCommand executes an administrative command instead of starting the server
+func runCommand(name string, args []string, config *models.Config, db *models.Database, store models.Storage) error {
+	switch name {
+	case "users":
+		return runUsersCommand(args, config, store)
+	case "audit":
+		if len(args) == 0 || args[0] != "export" {
+			return fmt.Errorf("usage: audit export")
+		}
+		return runAuditExportCommand(args[1:], store)
+	case "migrate":
+		return runMigrateCommand(args, db)
+	case "backup":
+		switch len(args) {
+		case 0:
+			_, err := db.RunBackup(config.Backup)
+			return err
+		case 1:
+			if err := db.Backup(args[0]); err != nil {
+				return err
+			}
+			slog.Info("Database backed up", "path", args[0])
+			return nil
+		default:
+			return fmt.Errorf("usage: backup [file]")
+		}
+	case "restore":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: restore <file>")
+		}
+		if config.DatabaseURL != "" || config.DatabasePath == models.MemoryDatabasePath {
+			return fmt.Errorf("restore is only supported for SQLite database files")
+		}
+		// Release the database file before swapping it
+		if err := db.Close(); err != nil {
+			return err
+		}
+		return models.RestoreBackup(args[0], config.DatabasePath)
+	case "integrity-check":
+		if err := db.IntegrityCheck(); err != nil {
+			return err
+		}
+		slog.Info("Integrity check passed")
+		return nil
+	case "reconcile":
+		return runReconcileCommand(args, config, store)
+	case "commands":
+		return runCommandsCommand(args, config, store)
+	case "encrypt-pii":
+		// Encrypts rows written before DATA_ENCRYPTION_KEY was configured
+		databaseStore, ok := store.(*models.VerificationStore)
+		if !ok {
+			return fmt.Errorf("encrypt-pii requires a database backed store")
+		}
+		count, err := databaseStore.EncryptExistingUsers()
+		if err != nil {
+			return err
+		}
+		slog.Info("Encrypted existing user rows", "count", count)
+		return nil
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+func runUsersCommand(args []string, config *models.Config, store models.Storage) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: users list|show|revoke|import|export")
+	}
+	switch args[0] {
+	case "import":
+		return runImportCommand(args[1:], config, store)
+	case "export":
+		return runUsersExportCommand(args[1:], store)
+	}
+
+	flags := flag.NewFlagSet("users "+args[0], flag.ContinueOnError)
+	all := flags.Bool("all", false, "include revoked verifications")
+	asJSON := flags.Bool("json", false, "print JSON instead of a table")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		return listUsers(store, models.UserFilter{IncludeRevoked: *all}, *asJSON)
+	case "show":
+		if flags.NArg() != 1 {
+			return fmt.Errorf("usage: users show <discord-id>")
+		}
+		export, err := store.ExportUserData(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		if export.User == nil && len(export.AuditEvents) == 0 {
+			return fmt.Errorf("no data stored for Discord ID %s", flags.Arg(0))
+		}
+		return writeJSON(os.Stdout, export)
+	case "revoke":
+		if flags.NArg() != 1 {
+			return fmt.Errorf("usage: users revoke <discord-id>")
+		}
+		discordHandler, err := newDiscordHandler(config, store)
+		if err != nil {
+			return err
+		}
+		return discordHandler.RevokeVerification(flags.Arg(0))
+	default:
+		return fmt.Errorf("unknown users command %q", args[0])
+	}
+}
+
+func listUsers(store models.Storage, filter models.UserFilter, asJSON bool) error {
+	if asJSON {
+		_, err := models.ExportUsers(os.Stdout, store, models.ExportFormatJSON, filter)
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "DISCORD ID\tNAME\tEMAIL\tVERIFIED\tSTATUS")
+	err := store.ForEachUser(filter, func(user *models.User) error {
+		status := "active"
+		if user.RevokedAt != nil {
+			status = "revoked"
+		}
+		_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", user.DiscordID, user.Name, user.Email, user.VerifiedAt.UTC().Format(time.RFC3339), status)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+func runUsersExportCommand(args []string, store models.Storage) error {
+	flags := flag.NewFlagSet("users export", flag.ContinueOnError)
+	format := flags.String("format", models.ExportFormatCSV, "csv, json or ndjson")
+	status := flags.String("status", "active", "active, revoked or all")
+	domain := flags.String("domain", "", "only users whose email belongs to this domain")
+	from := flags.String("from", "", "only users verified at or after this date or RFC 3339 time")
+	to := flags.String("to", "", "only users verified up to this date or before this RFC 3339 time")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter := models.UserFilter{Domain: *domain}
+	if err := models.ParseUserStatus(*status, &filter); err != nil {
+		return err
+	}
+	var err error
+	if filter.VerifiedAfter, filter.VerifiedBefore, err = models.ParseExportRange(*from, *to); err != nil {
+		return err
+	}
+
+	return exportToFile(flags, *format, func(out io.Writer, format string) (int, error) {
+		return models.ExportUsers(out, store, format, filter)
+	})
+}
+
+func runAuditExportCommand(args []string, store models.Storage) error {
+	flags := flag.NewFlagSet("audit export", flag.ContinueOnError)
+	format := flags.String("format", models.ExportFormatCSV, "csv, json or ndjson")
+	eventType := flags.String("type", "", "only events of this type, e.g. verified or revoked")
+	discordID := flags.String("discord-id", "", "only events of this Discord user")
+	from := flags.String("from", "", "only events at or after this date or RFC 3339 time")
+	to := flags.String("to", "", "only events up to this date or before this RFC 3339 time")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter := models.AuditFilter{EventType: *eventType, DiscordID: *discordID}
+	var err error
+	if filter.After, filter.Before, err = models.ParseExportRange(*from, *to); err != nil {
+		return err
+	}
+
+	return exportToFile(flags, *format, func(out io.Writer, format string) (int, error) {
+		return models.ExportAuditEvents(out, store, format, filter)
+	})
+}
+
+// exportToFile streams an export to the file named by the only positional argument, or stdout
+func exportToFile(flags *flag.FlagSet, format string, export func(out io.Writer, format string) (int, error)) error {
+	format, err := models.ParseExportFormat(format)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return fmt.Errorf("usage: %s [flags] [file]", flags.Name())
+	}
+
+	out := io.Writer(os.Stdout)
+	if flags.NArg() == 1 {
+		file, err := os.Create(flags.Arg(0))
+		if err != nil {
+			return fmt.Errorf("failed to create export file: %w", err)
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		out = file
+	}
+
+	buffered := bufio.NewWriter(out)
+	count, err := export(buffered, format)
+	if err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+
+	slog.Info("Exported", "records", count, "format", format)
+	return nil
+}
+
+func runImportCommand(args []string, config *models.Config, store models.Storage) error {
+	flags := flag.NewFlagSet("users import", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "validate the file and report without importing")
+	assignRoles := flags.Bool("assign-roles", false, "grant the configured roles to imported guild members")
+	format := flags.String("format", "", "csv or json, guessed from the file extension by default")
+	report := flags.String("report", "", "write the per-row report as CSV to this file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: users import [-dry-run] [-assign-roles] [-format csv|json] [-report file] <file>")
+	}
+
+	path := flags.Arg(0)
+	if *format == "" {
+		*format = models.ImportFormatFromName(path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open import file: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	records, err := models.ParseUserImport(file, *format)
+	if err != nil {
+		return err
+	}
+
+	discordHandler, err := newDiscordHandler(config, store)
+	if err != nil {
+		return err
+	}
+
+	results := discordHandler.ImportUsers(records, handlers.ImportOptions{DryRun: *dryRun, AssignRoles: *assignRoles})
+
+	out := io.Writer(os.Stdout)
+	if *report != "" {
+		reportFile, err := os.Create(*report)
+		if err != nil {
+			return fmt.Errorf("failed to create report file: %w", err)
+		}
+		defer func() {
+			_ = reportFile.Close()
+		}()
+		out = reportFile
+	}
+
+	return handlers.WriteImportReport(out, results)
+}
+
+// newDiscordHandler validates the Discord settings, which the server checks at
+// startup but administrative commands only need when they talk to Discord
+func newDiscordHandler(config *models.Config, store models.Storage) (*handlers.DiscordHandler, error) {
+	if err := config.ValidateDiscord(); err != nil {
+		return nil, err
+	}
+	return handlers.NewDiscordHandler(config, store)
+}
+
+func writeJSON(out io.Writer, value any) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func runCommandsCommand(args []string, config *models.Config, store models.Storage) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: commands sync|remove")
+	}
+
+	flags := flag.NewFlagSet("commands "+args[0], flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only print how the registered commands differ")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	discordHandler, err := newDiscordHandler(config, store)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "sync":
+		syncs, err := discordHandler.SyncCommands(*dryRun)
+		for _, sync := range syncs {
+			fmt.Println(sync)
+		}
+		return err
+	case "remove":
+		return discordHandler.RemoveCommands()
+	default:
+		return fmt.Errorf("unknown commands command %q", args[0])
+	}
+}
+
+func runReconcileCommand(args []string, config *models.Config, store models.Storage) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only print the role changes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	discordHandler, err := newDiscordHandler(config, store)
+	if err != nil {
+		return err
+	}
+
+	changes, err := discordHandler.Reconcile(*dryRun)
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Reconciled roles", "changes", len(changes), "dry_run", *dryRun)
+	return nil
+}
+
+// runMigrateCommand handles `migrate status`, `migrate up [version]` and `migrate down [version]`.
+// Without a version, up migrates to the latest schema and down rolls back a single migration.
+func runMigrateCommand(args []string, db *models.Database) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	target := models.LatestSchemaVersion()
+	if action == "down" {
+		target = max(current-1, 0)
+	}
+	if len(args) > 1 {
+		target, err = strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid schema version %q: %w", args[1], err)
+		}
+	}
+
+	switch action {
+	case "status":
+		return printMigrationStatus(db)
+	case "up":
+		if target < current {
+			return fmt.Errorf("schema is at version %d, use migrate down to roll back to %d", current, target)
+		}
+	case "down":
+		if target > current {
+			return fmt.Errorf("schema is at version %d, use migrate up to migrate to %d", current, target)
+		}
+	default:
+		return fmt.Errorf("unknown migrate action %q, expected status, up or down", action)
+	}
+
+	if err := db.MigrateTo(target); err != nil {
+		return err
+	}
+
+	slog.Info("Schema migrated", "from", current, "to", target)
+	return nil
+}
+
+func printMigrationStatus(db *models.Database) error {
+	states, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, state := range states {
+		applied := "pending"
+		if state.AppliedAt != nil {
+			applied = state.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\n", state.Version, state.Name, applied)
+	}
+
+	return w.Flush()
+}
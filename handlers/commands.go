@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// slashCommand pairs a command definition with the handler serving it
+type slashCommand struct {
+	definition *discordgo.ApplicationCommand
+	handle     func(s Responder, i *discordgo.InteractionCreate)
+}
+
+// slashCommands declares every command the bot registers in its guilds. Commands
+// missing here are removed from Discord on the next sync. Handlers doing slow
+// work are wrapped in deferred so they answer within Discord's deadline.
+func (h *DiscordHandler) slashCommands() []slashCommand {
+	adminPermissions := int64(discordgo.PermissionAdministrator)
+
+	return []slashCommand{
+		{
+			definition: &discordgo.ApplicationCommand{
+				Name:        "verify-employee",
+				Description: "Verify your employee status to get the employee role",
+			},
+			handle: h.deferred(defaultDeferTimeout, h.handleVerifyCommand),
+		},
+		{
+			definition: &discordgo.ApplicationCommand{
+				Name:        "my-data",
+				Description: "Show the data stored about you",
+			},
+			handle: h.deferred(defaultDeferTimeout, h.handleMyDataCommand),
+		},
+		{
+			definition: &discordgo.ApplicationCommand{
+				Name:        "forget-me",
+				Description: "Delete your verification and personal data",
+			},
+			handle: h.handleForgetMeCommand,
+		},
+		{
+			definition: &discordgo.ApplicationCommand{
+				Name:                     "verify-panel",
+				Description:              "Post a message with a Verify button for employees",
+				DefaultMemberPermissions: &adminPermissions,
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:         discordgo.ApplicationCommandOptionChannel,
+						Name:         "channel",
+						Description:  "Channel to post the panel in, defaults to this channel",
+						ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+					},
+				},
+			},
+			handle: h.deferred(defaultDeferTimeout, h.handleVerifyPanelCommand),
+		},
+		{
+			definition: &discordgo.ApplicationCommand{
+				Name:                     "reload-config",
+				Description:              "Reload the bot configuration without restarting",
+				DefaultMemberPermissions: &adminPermissions,
+			},
+			handle: h.deferred(defaultDeferTimeout, h.handleReloadCommand),
+		},
+		{
+			definition: &discordgo.ApplicationCommand{
+				Name:                     "backup-database",
+				Description:              "Write a backup of the verification database",
+				DefaultMemberPermissions: &adminPermissions,
+			},
+			handle: h.deferred(backupTimeout, h.handleBackupCommand),
+		},
+		{
+			definition: &discordgo.ApplicationCommand{
+				Name:                     "import-users",
+				Description:              "Import previously verified employees from a CSV or JSON file",
+				DefaultMemberPermissions: &adminPermissions,
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionAttachment,
+						Name:        "file",
+						Description: "CSV or JSON file with discord_id, email and optional azure_user_id and name",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+						Name:        "dry-run",
+						Description: "Only validate the file and report what would be imported",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+						Name:        "assign-roles",
+						Description: "Grant the employee roles to imported members",
+					},
+				},
+			},
+			handle: h.deferred(importTimeout, h.handleImportUsersCommand),
+		},
+		{
+			definition: &discordgo.ApplicationCommand{
+				Name:                     "export-user-data",
+				Description:              "Export all data stored about a user as JSON",
+				DefaultMemberPermissions: &adminPermissions,
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionUser,
+						Name:        "user",
+						Description: "Discord user to export",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "azure-id",
+						Description: "Azure object ID to export",
+					},
+				},
+			},
+			handle: h.deferred(defaultDeferTimeout, h.handleExportUserDataCommand),
+		},
+	}
+}
+
+// applicationCommands returns the command definitions with the configured localizations applied
+func (h *DiscordHandler) applicationCommands() []*discordgo.ApplicationCommand {
+	config := h.currentConfig()
+
+	var commands []*discordgo.ApplicationCommand
+	for _, command := range h.slashCommands() {
+		definition := *command.definition
+		names := make(map[discordgo.Locale]string)
+		descriptions := make(map[discordgo.Locale]string)
+		for locale, localization := range config.CommandLocalizations[definition.Name] {
+			if localization.Name != "" {
+				names[discordgo.Locale(locale)] = localization.Name
+			}
+			if localization.Description != "" {
+				descriptions[discordgo.Locale(locale)] = localization.Description
+			}
+		}
+		if len(names) > 0 {
+			definition.NameLocalizations = &names
+		}
+		if len(descriptions) > 0 {
+			definition.DescriptionLocalizations = &descriptions
+		}
+		commands = append(commands, &definition)
+	}
+
+	return commands
+}
+
+// CommandSync describes how the commands registered in a guild differ from the declared ones
+type CommandSync struct {
+	GuildID   string   `json:"guild_id"`
+	Created   []string `json:"created,omitempty"`
+	Updated   []string `json:"updated,omitempty"`
+	Removed   []string `json:"removed,omitempty"`
+	Unchanged []string `json:"unchanged,omitempty"`
+}
+
+// Changed reports whether the guild's commands need to be overwritten
+func (c CommandSync) Changed() bool {
+	return len(c.Created) > 0 || len(c.Updated) > 0 || len(c.Removed) > 0
+}
+
+func (c CommandSync) String() string {
+	if !c.Changed() {
+		return fmt.Sprintf("guild %s: %d commands up to date", c.GuildID, len(c.Unchanged))
+	}
+	return fmt.Sprintf("guild %s: created [%s], updated [%s], removed [%s]", c.GuildID,
+		strings.Join(c.Created, ", "), strings.Join(c.Updated, ", "), strings.Join(c.Removed, ", "))
+}
+
+// SyncCommands compares the registered commands of every configured guild with the
+// declared ones and overwrites them in a single request where they differ, which
+// also removes retired commands. Unchanged guilds are left alone to avoid rate
+// limits. It only uses the REST API, so it also works without an open gateway connection.
+func (h *DiscordHandler) SyncCommands(dryRun bool) ([]CommandSync, error) {
+	appID, err := h.applicationID()
+	if err != nil {
+		return nil, err
+	}
+
+	desired := h.applicationCommands()
+
+	var syncs []CommandSync
+	for _, guild := range h.currentConfig().AllGuilds() {
+		existing, err := h.session.ApplicationCommands(appID, guild.ID)
+		if err != nil {
+			return syncs, fmt.Errorf("failed to list slash commands of guild %s: %w", guild.ID, err)
+		}
+
+		sync := diffCommands(guild.ID, desired, existing)
+		syncs = append(syncs, sync)
+		if dryRun || !sync.Changed() {
+			continue
+		}
+
+		if _, err := h.session.ApplicationCommandBulkOverwrite(appID, guild.ID, desired); err != nil {
+			return syncs, fmt.Errorf("failed to register slash commands in guild %s: %w", guild.ID, err)
+		}
+		slog.Info("Slash commands synced", "guild_id", guild.ID, "created", sync.Created, "updated", sync.Updated, "removed", sync.Removed)
+	}
+
+	return syncs, nil
+}
+
+// RemoveCommands deletes all of the bot's slash commands from every configured guild
+func (h *DiscordHandler) RemoveCommands() error {
+	appID, err := h.applicationID()
+	if err != nil {
+		return err
+	}
+
+	for _, guild := range h.currentConfig().AllGuilds() {
+		if _, err := h.session.ApplicationCommandBulkOverwrite(appID, guild.ID, []*discordgo.ApplicationCommand{}); err != nil {
+			return fmt.Errorf("failed to remove slash commands from guild %s: %w", guild.ID, err)
+		}
+		slog.Info("Slash commands removed", "guild_id", guild.ID)
+	}
+
+	return nil
+}
+
+// applicationID returns the bot's application ID, from the gateway state when connected
+func (h *DiscordHandler) applicationID() (string, error) {
+	if h.session.State != nil && h.session.State.User != nil {
+		return h.session.State.User.ID, nil
+	}
+
+	user, err := h.session.User("@me")
+	if err != nil {
+		return "", fmt.Errorf("failed to get bot user: %w", err)
+	}
+
+	return user.ID, nil
+}
+
+// diffCommands matches declared and registered commands by name
+func diffCommands(guildID string, desired, existing []*discordgo.ApplicationCommand) CommandSync {
+	sync := CommandSync{GuildID: guildID}
+
+	registered := make(map[string]*discordgo.ApplicationCommand, len(existing))
+	for _, command := range existing {
+		registered[command.Name] = command
+	}
+
+	for _, command := range desired {
+		current, ok := registered[command.Name]
+		delete(registered, command.Name)
+		switch {
+		case !ok:
+			sync.Created = append(sync.Created, command.Name)
+		case !reflect.DeepEqual(signatureOf(command), signatureOf(current)):
+			sync.Updated = append(sync.Updated, command.Name)
+		default:
+			sync.Unchanged = append(sync.Unchanged, command.Name)
+		}
+	}
+
+	for _, command := range existing {
+		if _, stale := registered[command.Name]; stale {
+			sync.Removed = append(sync.Removed, command.Name)
+		}
+	}
+
+	return sync
+}
+
+// commandSignature holds the fields of a command that are compared when syncing,
+// normalized so Discord's defaults match the zero values of the declarations
+type commandSignature struct {
+	Type                     discordgo.ApplicationCommandType
+	Description              string
+	DefaultMemberPermissions int64
+	NameLocalizations        map[discordgo.Locale]string
+	DescriptionLocalizations map[discordgo.Locale]string
+	Options                  []optionSignature
+}
+
+type optionSignature struct {
+	Type                     discordgo.ApplicationCommandOptionType
+	Name                     string
+	Description              string
+	Required                 bool
+	ChannelTypes             []discordgo.ChannelType
+	NameLocalizations        map[discordgo.Locale]string
+	DescriptionLocalizations map[discordgo.Locale]string
+	Options                  []optionSignature
+}
+
+func signatureOf(command *discordgo.ApplicationCommand) commandSignature {
+	signature := commandSignature{
+		Type:                     command.Type,
+		Description:              command.Description,
+		DefaultMemberPermissions: -1,
+		Options:                  optionSignatures(command.Options),
+	}
+	if signature.Type == 0 {
+		signature.Type = discordgo.ChatApplicationCommand
+	}
+	if command.DefaultMemberPermissions != nil {
+		signature.DefaultMemberPermissions = *command.DefaultMemberPermissions
+	}
+	if command.NameLocalizations != nil {
+		signature.NameLocalizations = nonEmpty(*command.NameLocalizations)
+	}
+	if command.DescriptionLocalizations != nil {
+		signature.DescriptionLocalizations = nonEmpty(*command.DescriptionLocalizations)
+	}
+	return signature
+}
+
+func optionSignatures(options []*discordgo.ApplicationCommandOption) []optionSignature {
+	if len(options) == 0 {
+		return nil
+	}
+
+	signatures := make([]optionSignature, 0, len(options))
+	for _, option := range options {
+		signatures = append(signatures, optionSignature{
+			Type:                     option.Type,
+			Name:                     option.Name,
+			Description:              option.Description,
+			Required:                 option.Required,
+			ChannelTypes:             nonEmptyChannelTypes(option.ChannelTypes),
+			NameLocalizations:        nonEmpty(option.NameLocalizations),
+			DescriptionLocalizations: nonEmpty(option.DescriptionLocalizations),
+			Options:                  optionSignatures(option.Options),
+		})
+	}
+	return signatures
+}
+
+func nonEmptyChannelTypes(channelTypes []discordgo.ChannelType) []discordgo.ChannelType {
+	if len(channelTypes) == 0 {
+		return nil
+	}
+	return channelTypes
+}
+
+func nonEmpty(localizations map[discordgo.Locale]string) map[discordgo.Locale]string {
+	if len(localizations) == 0 {
+		return nil
+	}
+	return localizations
+}
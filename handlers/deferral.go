@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// defaultDeferTimeout bounds deferred work of handlers without a specific timeout
+	defaultDeferTimeout = 30 * time.Second
+
+	// maxDeferTimeout stays below the 15 minute lifetime of interaction tokens,
+	// after which the deferred response can no longer be edited
+	maxDeferTimeout = 14 * time.Minute
+)
+
+// deferredWork does the slow part of a command and returns the message replacing
+// the deferred response. ctx is cancelled once the timeout expires.
+type deferredWork func(ctx context.Context, s Responder, i *discordgo.InteractionCreate) (*discordgo.WebhookEdit, error)
+
+// deferred turns work into a command handler that acknowledges the interaction
+// right away with an ephemeral "thinking" response, so the 3 second deadline
+// is always met, and edits that response with the result. Errors, panics and
+// timeouts are reported to the user in the same message.
+func (h *DiscordHandler) deferred(timeout time.Duration, work deferredWork) func(s Responder, i *discordgo.InteractionCreate) {
+	return h.deferResponse(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	}, timeout, work)
+}
+
+// deferredUpdate is deferred for message components, the result replaces the
+// message holding the clicked component instead of being sent as a new reply
+func (h *DiscordHandler) deferredUpdate(timeout time.Duration, work deferredWork) func(s Responder, i *discordgo.InteractionCreate) {
+	return h.deferResponse(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	}, timeout, work)
+}
+
+func (h *DiscordHandler) deferResponse(response *discordgo.InteractionResponse, timeout time.Duration, work deferredWork) func(s Responder, i *discordgo.InteractionCreate) {
+	if timeout <= 0 {
+		timeout = defaultDeferTimeout
+	}
+	timeout = min(timeout, maxDeferTimeout)
+
+	return func(s Responder, i *discordgo.InteractionCreate) {
+		if err := s.InteractionRespond(i.Interaction, response); err != nil {
+			slog.Error("Failed to defer interaction", "error", err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		type outcome struct {
+			edit *discordgo.WebhookEdit
+			err  error
+		}
+		done := make(chan outcome, 1)
+		go func() {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					slog.Error("Deferred interaction panicked", "panic", recovered, "stack", string(debug.Stack()))
+					done <- outcome{err: fmt.Errorf("internal error")}
+				}
+			}()
+			edit, err := work(ctx, s, i)
+			done <- outcome{edit: edit, err: err}
+		}()
+
+		var edit *discordgo.WebhookEdit
+		select {
+		case result := <-done:
+			edit = result.edit
+			if result.err != nil {
+				slog.Error("Deferred interaction failed", "command", interactionName(i), "error", result.err)
+				edit = contentEdit(fmt.Sprintf("Something went wrong: %v", result.err))
+			}
+		case <-ctx.Done():
+			slog.Error("Deferred interaction timed out", "command", interactionName(i), "timeout", timeout)
+			edit = contentEdit("This is taking longer than expected, please try again later.")
+		}
+		if edit == nil {
+			edit = contentEdit("Done.")
+		}
+
+		if _, err := s.InteractionResponseEdit(i.Interaction, edit); err != nil {
+			slog.Error("Failed to edit deferred interaction response", "command", interactionName(i), "error", err)
+		}
+	}
+}
+
+// contentEdit replaces a deferred response with a plain text message
+func contentEdit(content string) *discordgo.WebhookEdit {
+	return &discordgo.WebhookEdit{Content: &content}
+}
+
+// interactionName returns the command name or component ID for logging
+func interactionName(i *discordgo.InteractionCreate) string {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		return i.ApplicationCommandData().Name
+	case discordgo.InteractionMessageComponent:
+		return i.MessageComponentData().CustomID
+	}
+	return i.Type.String()
+}
@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"math"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/shopwarelabs/discord-bot/models"
 
@@ -12,11 +17,23 @@ import (
 
 type DiscordHandler struct {
 	session *discordgo.Session
-	config  *models.Config
-	store   *models.VerificationStore
+	config  atomic.Pointer[models.Config]
+	store   models.Storage
+
+	// reloadConfig is invoked by the reload-config admin command
+	reloadConfig func() ([]models.ConfigChange, error)
+
+	// commandCooldown throttles /verify-employee per user, nil disables it
+	commandCooldown *RateLimiter
+
+	// backup is invoked by the backup-database admin command
+	backup func() (string, error)
+
+	// commands maps slash command names to their declarations and handlers
+	commands map[string]slashCommand
 }
 
-func NewDiscordHandler(config *models.Config, store *models.VerificationStore) (*DiscordHandler, error) {
+func NewDiscordHandler(config *models.Config, store models.Storage) (*DiscordHandler, error) {
 	dg, err := discordgo.New("Bot " + config.DiscordToken)
 	if err != nil {
 		return nil, err
@@ -24,9 +41,14 @@ func NewDiscordHandler(config *models.Config, store *models.VerificationStore) (
 
 	handler := &DiscordHandler{
 		session: dg,
-		config:  config,
 		store:   store,
 	}
+	handler.config.Store(config)
+
+	handler.commands = make(map[string]slashCommand)
+	for _, command := range handler.slashCommands() {
+		handler.commands[command.definition.Name] = command
+	}
 
 	dg.AddHandler(handler.ready)
 	dg.AddHandler(handler.interactionCreate)
@@ -40,53 +62,123 @@ func (h *DiscordHandler) Start() error {
 		return err
 	}
 
-	_, err = h.session.ApplicationCommandCreate(h.session.State.User.ID, h.config.DiscordGuildID, &discordgo.ApplicationCommand{
-		Name:        "verify-employee",
-		Description: "Verify your employee status to get the employee role",
-	})
-	if err != nil {
-		return fmt.Errorf("cannot create slash command: %v", err)
+	if _, err := h.SyncCommands(false); err != nil {
+		return err
 	}
 
-	slog.Info("Discord bot started", "guild_id", h.config.DiscordGuildID)
+	slog.Info("Discord bot started", "guilds", len(h.currentConfig().AllGuilds()))
 	return nil
 }
 
+// currentConfig returns the active configuration, which may be swapped by a reload
+func (h *DiscordHandler) currentConfig() *models.Config {
+	return h.config.Load()
+}
+
+// ApplyConfig atomically replaces the configuration used for new interactions
+func (h *DiscordHandler) ApplyConfig(config *models.Config) {
+	h.config.Store(config)
+}
+
+// SetCommandCooldown throttles how often a user may run /verify-employee
+func (h *DiscordHandler) SetCommandCooldown(limiter *RateLimiter) {
+	h.commandCooldown = limiter
+}
+
+// SetConfigReloader registers the function called by the reload-config command
+func (h *DiscordHandler) SetConfigReloader(reload func() ([]models.ConfigChange, error)) {
+	h.reloadConfig = reload
+}
+
+// SetBackupFunc registers the function called by the backup-database command
+func (h *DiscordHandler) SetBackupFunc(backup func() (string, error)) {
+	h.backup = backup
+}
+
 func (h *DiscordHandler) Stop() error {
+	if h.currentConfig().RemoveCommandsOnShutdown {
+		if err := h.RemoveCommands(); err != nil {
+			slog.Error("Failed to remove slash commands", "error", err)
+		}
+	}
+
 	return h.session.Close()
 }
 
+// maxHeartbeatAge is how long the gateway may go without a heartbeat ACK
+// before the connection is considered unhealthy
+const maxHeartbeatAge = 2 * time.Minute
+
+// HealthCheck reports the state of the Discord gateway connection
+func (h *DiscordHandler) HealthCheck() ComponentStatus {
+	if h == nil || h.session == nil {
+		return componentDown("discord handler not initialized", nil)
+	}
+
+	h.session.RLock()
+	dataReady := h.session.DataReady
+	lastAck := h.session.LastHeartbeatAck
+	lastSent := h.session.LastHeartbeatSent
+	h.session.RUnlock()
+
+	details := map[string]any{
+		"data_ready":         dataReady,
+		"last_heartbeat_ack": lastAck.Format(time.RFC3339),
+		"heartbeat_latency":  lastAck.Sub(lastSent).String(),
+	}
+
+	if !dataReady {
+		return componentDown("gateway not connected", details)
+	}
+
+	if time.Since(lastAck) > maxHeartbeatAge {
+		return componentDown("no heartbeat ACK received recently", details)
+	}
+
+	return componentUp(details)
+}
+
 func (h *DiscordHandler) ready(s *discordgo.Session, event *discordgo.Ready) {
 	slog.Info("Discord bot logged in", "user", event.User.Username)
 }
 
 func (h *DiscordHandler) interactionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	if i.ApplicationCommandData().Name == "verify-employee" {
-		h.handleVerifyCommand(s, i)
-	}
+	h.dispatchInteraction(s, i)
 }
 
-func (h *DiscordHandler) handleVerifyCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	if h.store.IsUserVerified(i.Member.User.ID) {
-		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Content: "You are already verified!",
-				Flags:   discordgo.MessageFlagsEphemeral,
-			},
-		})
-		if err != nil {
-			slog.Error("Failed to respond to interaction", "error", err)
-		}
+// dispatchInteraction routes an interaction received over the gateway or the
+// HTTP interactions endpoint to its handler
+func (h *DiscordHandler) dispatchInteraction(s Responder, i *discordgo.InteractionCreate) {
+	// All commands are guild commands, ignore anything arriving via DMs
+	if i.Member == nil {
 		return
 	}
 
-	verificationURL := fmt.Sprintf("%s/employee/start?state=%s", h.config.BaseURL, i.Member.User.ID)
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		if command, ok := h.commands[i.ApplicationCommandData().Name]; ok {
+			command.handle(s, i)
+		}
+	case discordgo.InteractionMessageComponent:
+		switch i.MessageComponentData().CustomID {
+		case forgetMeConfirmID:
+			h.deferredUpdate(defaultDeferTimeout, h.handleForgetMeConfirm)(s, i)
+		case forgetMeCancelID:
+			h.handleForgetMeCancel(s, i)
+		case verifyStartID:
+			h.deferred(defaultDeferTimeout, h.handleVerifyCommand)(s, i)
+		case verifyCheckStatusID:
+			h.deferredUpdate(defaultDeferTimeout, h.handleCheckStatus)(s, i)
+		}
+	}
+}
 
+// respondEphemeral replies with a message only the invoking user can see
+func respondEphemeral(s Responder, i *discordgo.InteractionCreate, content string) {
 	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content: fmt.Sprintf("Please click the following link to verify your employee status:\n%s", verificationURL),
+			Content: content,
 			Flags:   discordgo.MessageFlagsEphemeral,
 		},
 	})
@@ -95,39 +187,136 @@ func (h *DiscordHandler) handleVerifyCommand(s *discordgo.Session, i *discordgo.
 	}
 }
 
-// VerifyUserDirectly verifies a user directly with Azure ID and email and assigns the role
-func (h *DiscordHandler) VerifyUserDirectly(discordID, azureUserID, email string) error {
-	if !strings.HasSuffix(email, "@shopware.com") {
-		return fmt.Errorf("email domain not allowed")
+// isAdmin reports whether the invoking member has administrator permissions
+func isAdmin(i *discordgo.InteractionCreate) bool {
+	return i.Member != nil && i.Member.Permissions&discordgo.PermissionAdministrator != 0
+}
+
+func (h *DiscordHandler) handleReloadCommand(ctx context.Context, s Responder, i *discordgo.InteractionCreate) (*discordgo.WebhookEdit, error) {
+	var content string
+	switch {
+	case !isAdmin(i):
+		content = "You need administrator permissions to reload the configuration."
+	case h.reloadConfig == nil:
+		content = "Configuration reload is not available."
+	default:
+		changes, err := h.reloadConfig()
+		if err != nil {
+			content = fmt.Sprintf("Configuration reload failed: %v", err)
+			break
+		}
+		if len(changes) == 0 {
+			content = "Configuration reloaded, nothing changed."
+			break
+		}
+		lines := make([]string, 0, len(changes))
+		for _, change := range changes {
+			lines = append(lines, "- "+change.String())
+		}
+		content = "Configuration reloaded:\n" + strings.Join(lines, "\n")
 	}
 
-	if h.store.IsUserVerifiedByAzureID(azureUserID) {
-		return fmt.Errorf("user is already verified")
+	return contentEdit(content), nil
+}
+
+// backupTimeout bounds an admin triggered backup including its integrity check
+const backupTimeout = 5 * time.Minute
+
+func (h *DiscordHandler) handleBackupCommand(ctx context.Context, s Responder, i *discordgo.InteractionCreate) (*discordgo.WebhookEdit, error) {
+	var content string
+	switch {
+	case !isAdmin(i):
+		content = "You need administrator permissions to back up the database."
+	case h.backup == nil:
+		content = "Database backups are not available."
+	default:
+		path, err := h.backup()
+		if err != nil {
+			slog.Error("Admin triggered backup failed", "error", err)
+			content = fmt.Sprintf("Backup failed: %v", err)
+			break
+		}
+		slog.Info("Admin triggered backup", "admin_id", i.Member.User.ID, "path", path)
+		content = fmt.Sprintf("Backup written to `%s` and passed the integrity check.", path)
 	}
 
-	slog.Info("Assigning role to user", "discord_id", discordID, "azure_id", azureUserID, "guild_id", h.config.DiscordGuildID, "role_id", h.config.DiscordRoleID)
-	err := h.session.GuildMemberRoleAdd(h.config.DiscordGuildID, discordID, h.config.DiscordRoleID)
-	if err != nil {
-		return fmt.Errorf("failed to add role: %v", err)
+	return contentEdit(content), nil
+}
+
+func (h *DiscordHandler) handleVerifyCommand(ctx context.Context, s Responder, i *discordgo.InteractionCreate) (*discordgo.WebhookEdit, error) {
+	config := h.currentConfig()
+
+	if h.commandCooldown != nil {
+		if allowed, retryAfter := h.commandCooldown.Allow(i.Member.User.ID); !allowed {
+			seconds := strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+			return contentEdit(renderMessage(config.Messages.CommandCooldown, "seconds", seconds)), nil
+		}
+	}
+
+	if user, ok := h.store.GetUser(i.Member.User.ID); ok {
+		h.grantRolesInGuild(config, i.GuildID, user)
+		return verifiedStatus(config, user), nil
+	}
+
+	return h.verifyPrompt(config, i.Member.User.ID, "")
+}
+
+// VerifyUserDirectly verifies a user directly with Azure ID and email and assigns the role.
+// The caller checks that the email domain is allowed.
+func (h *DiscordHandler) VerifyUserDirectly(discordID, azureUserID, email string) error {
+	config := h.currentConfig()
+
+	if h.store.IsUserVerifiedByAzureID(azureUserID) {
+		h.recordAuditEvent(discordID, models.AuditVerificationFailed, "Microsoft account already linked to another Discord user")
+		return fmt.Errorf("user is already verified")
 	}
 
 	user, err := h.session.User(discordID)
-	var userName string
 	if err != nil {
 		return fmt.Errorf("failed to get Discord user info: %v", err)
-	} else {
-		userName = user.Username
 	}
+	userName := user.Username
 
+	// Persist first, so a guild the bot cannot manage doesn't leave the user with
+	// roles but without a verification. Roles missing there can be granted later
+	// with "Check status" or a reconcile run.
 	if err := h.store.CreateUserWithAzureID(discordID, azureUserID, email, userName); err != nil {
 		return fmt.Errorf("failed to create user record: %v", err)
 	}
+	h.recordAuditEvent(discordID, models.AuditVerified, "verified via Microsoft sign-in")
+
+	// Roles are granted in every configured guild the user has joined
+	granted, failed := h.grantRoles(config, discordID, email)
+	for _, guild := range config.AllGuilds() {
+		if err, ok := failed[guild.ID]; ok {
+			slog.Error("Failed to grant roles to verified user", "discord_id", discordID, "guild_id", guild.ID, "error", err)
+			h.recordAuditEvent(discordID, models.AuditRoleGrantFailed, "roles not granted in guild "+guild.ID)
+		}
+	}
 
 	channel, err := h.session.UserChannelCreate(discordID)
 	if err == nil {
-		_, _ = h.session.ChannelMessageSend(channel.ID, fmt.Sprintf("Congratulations! Your employee status has been verified. Email: %s", email))
+		_, _ = h.session.ChannelMessageSend(channel.ID, renderMessage(config.Messages.VerifiedDM, "email", email))
 	}
 
+	h.logToGuilds(granted, fmt.Sprintf("<@%s> (%s) verified via Microsoft sign-in", discordID, userName))
+
 	slog.Info("User verified", "discord_id", discordID, "azure_id", azureUserID, "email", email)
 	return nil
 }
+
+// recordAuditEvent stores an audit event, logging instead of failing the caller
+func (h *DiscordHandler) recordAuditEvent(discordID, eventType, details string) {
+	if err := h.store.RecordAuditEvent(discordID, eventType, details); err != nil {
+		slog.Error("Failed to record audit event", "event_type", eventType, "error", err)
+	}
+}
+
+// renderMessage replaces {key} placeholders in a configured message template
+func renderMessage(template string, keyValues ...string) string {
+	oldNew := make([]string, 0, len(keyValues))
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		oldNew = append(oldNew, "{"+keyValues[i]+"}", keyValues[i+1])
+	}
+	return strings.NewReplacer(oldNew...).Replace(template)
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/shopwarelabs/discord-bot/models"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	forgetMeConfirmID = "forget-me:confirm"
+	forgetMeCancelID  = "forget-me:cancel"
+
+	// maxListedAuditEvents keeps /my-data below Discord's message length limit
+	maxListedAuditEvents = 15
+)
+
+// handleMyDataCommand is deferred, the export reads every audit event of the user
+func (h *DiscordHandler) handleMyDataCommand(ctx context.Context, s Responder, i *discordgo.InteractionCreate) (*discordgo.WebhookEdit, error) {
+	export, err := h.store.ExportUserData(i.Member.User.ID)
+	if err != nil {
+		slog.Error("Failed to load user data", "discord_id", i.Member.User.ID, "error", err)
+		return contentEdit("Failed to load your data, please try again later."), nil
+	}
+
+	if export.User == nil && len(export.AuditEvents) == 0 {
+		return contentEdit("We don't store any data about you."), nil
+	}
+
+	var b strings.Builder
+	if user := export.User; user != nil {
+		b.WriteString("**Verification**\n")
+		fmt.Fprintf(&b, "Discord ID: %s\n", user.DiscordID)
+		fmt.Fprintf(&b, "Discord username: %s\n", user.Name)
+		fmt.Fprintf(&b, "Email: %s\n", user.Email)
+		fmt.Fprintf(&b, "Microsoft account ID: %s\n", user.AzureUserID)
+		fmt.Fprintf(&b, "Verified: <t:%d:f>\n", user.VerifiedAt.Unix())
+	} else {
+		b.WriteString("You are not verified.\n")
+	}
+
+	fmt.Fprintf(&b, "\n**Audit events** (%d)\n", len(export.AuditEvents))
+	events := export.AuditEvents
+	if len(events) > maxListedAuditEvents {
+		events = events[len(events)-maxListedAuditEvents:]
+		fmt.Fprintf(&b, "Showing the latest %d.\n", maxListedAuditEvents)
+	}
+	for _, event := range events {
+		fmt.Fprintf(&b, "- <t:%d:f> %s", event.CreatedAt.Unix(), event.EventType)
+		if event.Details != "" {
+			fmt.Fprintf(&b, ": %s", event.Details)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\nUse /forget-me to delete your data.")
+
+	return contentEdit(b.String()), nil
+}
+
+func (h *DiscordHandler) handleForgetMeCommand(s Responder, i *discordgo.InteractionCreate) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "This removes your employee role and deletes your verification and personal data. " +
+				"An anonymous record of the deletion is kept. Do you want to continue?",
+			Flags: discordgo.MessageFlagsEphemeral,
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.Button{
+							Label:    "Delete my data",
+							Style:    discordgo.DangerButton,
+							CustomID: forgetMeConfirmID,
+						},
+						discordgo.Button{
+							Label:    "Cancel",
+							Style:    discordgo.SecondaryButton,
+							CustomID: forgetMeCancelID,
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("Failed to respond to interaction", "error", err)
+	}
+}
+
+// handleForgetMeConfirm is deferred, removing the roles takes requests to every guild
+func (h *DiscordHandler) handleForgetMeConfirm(ctx context.Context, s Responder, i *discordgo.InteractionCreate) (*discordgo.WebhookEdit, error) {
+	config := h.currentConfig()
+	discordID := i.Member.User.ID
+
+	if err := h.removeRoles(config, discordID); err != nil {
+		slog.Error("Failed to remove roles", "discord_id", discordID, "error", err)
+	}
+
+	content := "Your data has been deleted and your employee role removed."
+	if err := h.store.DeleteUserData(discordID); err != nil {
+		slog.Error("Failed to delete user data", "discord_id", discordID, "error", err)
+		content = "Failed to delete your data, please try again later or contact an administrator."
+	} else {
+		slog.Info("User data deleted on request", "discord_id", discordID)
+	}
+
+	return &discordgo.WebhookEdit{
+		Content:    &content,
+		Components: &[]discordgo.MessageComponent{},
+	}, nil
+}
+
+func (h *DiscordHandler) handleForgetMeCancel(s Responder, i *discordgo.InteractionCreate) {
+	updateComponentMessage(s, i, "Nothing was deleted.")
+}
+
+// updateComponentMessage replaces the message holding the clicked button and removes its buttons
+func updateComponentMessage(s Responder, i *discordgo.InteractionCreate, content string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+	if err != nil {
+		slog.Error("Failed to respond to interaction", "error", err)
+	}
+}
+
+// handleExportUserDataCommand is deferred like /my-data and attaches the export as a JSON file
+func (h *DiscordHandler) handleExportUserDataCommand(ctx context.Context, s Responder, i *discordgo.InteractionCreate) (*discordgo.WebhookEdit, error) {
+	if !isAdmin(i) {
+		return contentEdit("You need administrator permissions to export user data."), nil
+	}
+
+	var discordID string
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "user":
+			discordID = option.UserValue(nil).ID
+		case "azure-id":
+			user, ok := h.store.GetUserByAzureID(option.StringValue())
+			if !ok {
+				return contentEdit("No user found for that Azure ID."), nil
+			}
+			discordID = user.DiscordID
+		}
+	}
+
+	if discordID == "" {
+		return contentEdit("Please provide a user or an Azure ID."), nil
+	}
+
+	export, err := h.store.ExportUserData(discordID)
+	if err != nil {
+		slog.Error("Failed to export user data", "discord_id", discordID, "error", err)
+		return contentEdit("Failed to export user data."), nil
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		slog.Error("Failed to encode user data", "discord_id", discordID, "error", err)
+		return contentEdit("Failed to export user data."), nil
+	}
+
+	h.recordAuditEvent(discordID, models.AuditDataExported, "exported by administrator "+i.Member.User.ID)
+
+	content := fmt.Sprintf("Data export for <@%s>", discordID)
+	return &discordgo.WebhookEdit{
+		Content: &content,
+		Files: []*discordgo.File{
+			{
+				Name:        fmt.Sprintf("user-data-%s.json", discordID),
+				ContentType: "application/json",
+				Reader:      bytes.NewReader(data),
+			},
+		},
+	}, nil
+}
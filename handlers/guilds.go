@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+
+	"github.com/shopwarelabs/discord-bot/models"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// guildMember returns the member in the guild, or nil when the user has not joined it
+func (h *DiscordHandler) guildMember(guildID, discordID string) (*discordgo.Member, error) {
+	member, err := h.session.GuildMember(guildID, discordID)
+	if err != nil {
+		var restErr *discordgo.RESTError
+		if errors.As(err, &restErr) && restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeUnknownMember {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get member of guild %s: %w", guildID, err)
+	}
+	return member, nil
+}
+
+// grantGuildRoles adds the roles the guild grants for email to a member
+func (h *DiscordHandler) grantGuildRoles(guild models.GuildConfig, discordID, email string) error {
+	var errs []error
+	for _, roleID := range guild.RoleIDsForEmail(email) {
+		slog.Info("Assigning role to user", "discord_id", discordID, "guild_id", guild.ID, "role_id", roleID)
+		if err := h.session.GuildMemberRoleAdd(guild.ID, discordID, roleID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to add role %s in guild %s: %w", roleID, guild.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// grantRoles adds the roles for email in every configured guild the user has joined.
+// A failing guild does not stop the others, it returns the guilds in which roles
+// were granted and the errors of the failed ones keyed by guild ID.
+func (h *DiscordHandler) grantRoles(config *models.Config, discordID, email string) ([]models.GuildConfig, map[string]error) {
+	var granted []models.GuildConfig
+	failed := make(map[string]error)
+	for _, guild := range config.AllGuilds() {
+		if !guild.AcceptsEmail(email) {
+			continue
+		}
+
+		member, err := h.guildMember(guild.ID, discordID)
+		if err != nil {
+			failed[guild.ID] = err
+			continue
+		}
+		if member == nil {
+			continue
+		}
+
+		if err := h.grantGuildRoles(guild, discordID, email); err != nil {
+			failed[guild.ID] = err
+			continue
+		}
+		granted = append(granted, guild)
+	}
+
+	return granted, failed
+}
+
+// joinGuildErrors combines the errors returned by grantRoles in guild ID order
+func joinGuildErrors(failed map[string]error) error {
+	var errs []error
+	for _, guildID := range slices.Sorted(maps.Keys(failed)) {
+		errs = append(errs, failed[guildID])
+	}
+	return errors.Join(errs...)
+}
+
+// removeRoles takes the roles managed by the bot away from the user in every configured guild
+func (h *DiscordHandler) removeRoles(config *models.Config, discordID string) error {
+	var errs []error
+	for _, guild := range config.AllGuilds() {
+		member, err := h.guildMember(guild.ID, discordID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if member == nil {
+			continue
+		}
+
+		managed := guild.ManagedRoleIDs()
+		for _, roleID := range member.Roles {
+			if !managed[roleID] {
+				continue
+			}
+			if err := h.session.GuildMemberRoleRemove(guild.ID, discordID, roleID); err != nil {
+				errs = append(errs, fmt.Errorf("failed to remove role %s in guild %s: %w", roleID, guild.ID, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// logToGuilds posts a notice to the log channels of the given guilds
+func (h *DiscordHandler) logToGuilds(guilds []models.GuildConfig, content string) {
+	for _, guild := range guilds {
+		if guild.LogChannelID == "" {
+			continue
+		}
+		// Mentions identify the user without pinging them
+		_, err := h.session.ChannelMessageSendComplex(guild.LogChannelID, &discordgo.MessageSend{
+			Content:         content,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		if err != nil {
+			slog.Error("Failed to post to log channel", "guild_id", guild.ID, "channel_id", guild.LogChannelID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/shopwarelabs/discord-bot/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	statusUp   = "up"
+	statusDown = "down"
+
+	// healthCheckTimeout bounds how long a single readiness probe may take
+	healthCheckTimeout = 3 * time.Second
+)
+
+// ComponentStatus describes the health of a single dependency
+type ComponentStatus struct {
+	Status  string         `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+func componentUp(details map[string]any) ComponentStatus {
+	return ComponentStatus{Status: statusUp, Details: details}
+}
+
+func componentDown(message string, details map[string]any) ComponentStatus {
+	return ComponentStatus{Status: statusDown, Error: message, Details: details}
+}
+
+type HealthHandler struct {
+	db             *models.Database
+	discordHandler *DiscordHandler
+	oauthHandler   *OAuthHandler
+	startedAt      time.Time
+}
+
+func NewHealthHandler(db *models.Database, discordHandler *DiscordHandler, oauthHandler *OAuthHandler) *HealthHandler {
+	return &HealthHandler{
+		db:             db,
+		discordHandler: discordHandler,
+		oauthHandler:   oauthHandler,
+		startedAt:      time.Now(),
+	}
+}
+
+// Livez reports whether the process is running and able to serve requests
+func (h *HealthHandler) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "alive",
+		"uptime": time.Since(h.startedAt).Round(time.Second).String(),
+	})
+}
+
+// Readyz checks every dependency and reports per-component details
+func (h *HealthHandler) Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+	defer cancel()
+
+	components := map[string]ComponentStatus{
+		"database": h.checkDatabase(ctx),
+		"discord":  h.discordHandler.HealthCheck(),
+		"oidc":     h.oauthHandler.HealthCheck(ctx),
+		"jobs":     h.checkJobs(),
+	}
+
+	status := http.StatusOK
+	overall := "ready"
+	for _, component := range components {
+		if component.Status != statusUp {
+			status = http.StatusServiceUnavailable
+			overall = "not ready"
+			break
+		}
+	}
+
+	c.JSON(status, gin.H{
+		"status":     overall,
+		"components": components,
+	})
+}
+
+func (h *HealthHandler) checkDatabase(ctx context.Context) ComponentStatus {
+	if h.db == nil {
+		return componentDown("database not initialized", nil)
+	}
+
+	start := time.Now()
+	if err := h.db.Ping(ctx); err != nil {
+		return componentDown(err.Error(), nil)
+	}
+
+	return componentUp(map[string]any{
+		"latency_ms": time.Since(start).Milliseconds(),
+	})
+}
+
+func (h *HealthHandler) checkJobs() ComponentStatus {
+	if h.db == nil {
+		return componentDown("database not initialized", nil)
+	}
+
+	stats := h.db.JobStats()
+	details := map[string]any{
+		"prune_last_run":     stats.LastPrune.UTC().Format(time.RFC3339),
+		"prune_last_deleted": stats.LastPruneDeleted,
+		"prune_runs":         stats.PruneRuns,
+		"prune_errors":       stats.PruneErrors,
+		"prune_total":        stats.TotalDeleted,
+		"maintenance_runs":   stats.MaintenanceRuns,
+		"maintenance_errors": stats.MaintenanceErrors,
+	}
+	if !stats.LastMaintenance.IsZero() {
+		details["maintenance_last_run"] = stats.LastMaintenance.UTC().Format(time.RFC3339)
+	}
+	if stats.BackupInterval > 0 {
+		details["backup_runs"] = stats.BackupRuns
+		details["backup_errors"] = stats.BackupErrors
+		if !stats.LastBackup.IsZero() {
+			details["backup_last_run"] = stats.LastBackup.UTC().Format(time.RFC3339)
+		}
+	}
+
+	if stats.PruneInterval == 0 {
+		return componentDown("pruning job not started", details)
+	}
+
+	// Allow one missed tick before reporting the job as stale
+	if time.Since(stats.LastPrune) > 2*stats.PruneInterval {
+		return componentDown("pruning job is stale", details)
+	}
+
+	return componentUp(details)
+}
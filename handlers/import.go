@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopwarelabs/discord-bot/models"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Import result statuses
+const (
+	ImportStatusImported    = "imported"
+	ImportStatusWouldImport = "would_import"
+	ImportStatusSkipped     = "skipped"
+	ImportStatusFailed      = "failed"
+)
+
+const (
+	// maxImportUploadSize limits import files uploaded through Discord
+	maxImportUploadSize = 5 << 20
+
+	// importTimeout bounds downloading, importing and assigning roles for an upload
+	importTimeout = 10 * time.Minute
+)
+
+// ImportOptions controls a bulk import
+type ImportOptions struct {
+	// DryRun validates every row without writing anything
+	DryRun bool
+	// AssignRoles grants the configured roles to imported members of the guild
+	AssignRoles bool
+}
+
+// ImportResult is the outcome of importing a single row
+type ImportResult struct {
+	Row       int    `json:"row"`
+	DiscordID string `json:"discord_id"`
+	Email     string `json:"email"`
+	Status    string `json:"status"`
+	Message   string `json:"message,omitempty"`
+}
+
+// ImportSummary counts import results per status
+func ImportSummary(results []ImportResult) map[string]int {
+	summary := make(map[string]int)
+	for _, result := range results {
+		summary[result.Status]++
+	}
+	return summary
+}
+
+// ImportUsers validates records against the domain policy and stores them as
+// verified users. Rows already verified are skipped, invalid rows fail without
+// stopping the import.
+func (h *DiscordHandler) ImportUsers(records []models.ImportRecord, options ImportOptions) []ImportResult {
+	config := h.currentConfig()
+
+	seenDiscordIDs := make(map[string]bool, len(records))
+	seenAzureIDs := make(map[string]bool, len(records))
+
+	results := make([]ImportResult, 0, len(records))
+	for _, record := range records {
+		result := ImportResult{Row: record.Row, DiscordID: record.DiscordID, Email: record.Email}
+
+		fail := func(status, message string) {
+			result.Status = status
+			result.Message = message
+			results = append(results, result)
+		}
+
+		switch {
+		case !models.IsSnowflake(record.DiscordID):
+			fail(ImportStatusFailed, "invalid Discord ID")
+			continue
+		case !strings.Contains(record.Email, "@"):
+			fail(ImportStatusFailed, "invalid email")
+			continue
+		case !config.IsEmailAllowed(record.Email):
+			fail(ImportStatusFailed, "email domain not allowed")
+			continue
+		case seenDiscordIDs[record.DiscordID]:
+			fail(ImportStatusFailed, "duplicate Discord ID in file")
+			continue
+		case record.AzureUserID != "" && seenAzureIDs[record.AzureUserID]:
+			fail(ImportStatusFailed, "duplicate Azure ID in file")
+			continue
+		case h.store.IsUserVerified(record.DiscordID):
+			fail(ImportStatusSkipped, "already verified")
+			continue
+		case record.AzureUserID != "" && h.store.IsUserVerifiedByAzureID(record.AzureUserID):
+			fail(ImportStatusFailed, "Microsoft account already linked to another Discord user")
+			continue
+		}
+		seenDiscordIDs[record.DiscordID] = true
+		if record.AzureUserID != "" {
+			seenAzureIDs[record.AzureUserID] = true
+		}
+
+		if options.DryRun {
+			result.Status = ImportStatusWouldImport
+			results = append(results, result)
+			continue
+		}
+
+		var err error
+		if record.AzureUserID != "" {
+			err = h.store.CreateUserWithAzureID(record.DiscordID, record.AzureUserID, record.Email, record.Name)
+		} else {
+			err = h.store.CreateUser(record.DiscordID, record.Email, record.Name)
+		}
+		if err != nil {
+			fail(ImportStatusFailed, err.Error())
+			continue
+		}
+		h.recordAuditEvent(record.DiscordID, models.AuditVerified, "imported from a previous verification")
+
+		result.Status = ImportStatusImported
+		if options.AssignRoles {
+			if _, failed := h.grantRoles(config, record.DiscordID, record.Email); len(failed) > 0 {
+				result.Message = "roles not assigned: " + strings.ReplaceAll(joinGuildErrors(failed).Error(), "\n", "; ")
+			}
+		}
+		results = append(results, result)
+	}
+
+	summary := ImportSummary(results)
+	slog.Info("Imported users", "dry_run", options.DryRun, "imported", summary[ImportStatusImported],
+		"would_import", summary[ImportStatusWouldImport], "skipped", summary[ImportStatusSkipped], "failed", summary[ImportStatusFailed])
+
+	return results
+}
+
+// WriteImportReport writes import results as CSV
+func WriteImportReport(w io.Writer, results []ImportResult) error {
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"row", "discord_id", "email", "status", "message"})
+	for _, result := range results {
+		_ = writer.Write([]string{strconv.Itoa(result.Row), result.DiscordID, result.Email, result.Status, result.Message})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (h *DiscordHandler) handleImportUsersCommand(ctx context.Context, s Responder, i *discordgo.InteractionCreate) (*discordgo.WebhookEdit, error) {
+	if !isAdmin(i) {
+		return contentEdit("You need administrator permissions to import users."), nil
+	}
+
+	data := i.ApplicationCommandData()
+	var attachment *discordgo.MessageAttachment
+	var options ImportOptions
+	for _, option := range data.Options {
+		switch option.Name {
+		case "file":
+			attachmentID, ok := option.Value.(string)
+			if !ok || data.Resolved == nil {
+				return contentEdit("The attached file could not be read, please try again."), nil
+			}
+			attachment = data.Resolved.Attachments[attachmentID]
+		case "dry-run":
+			options.DryRun = option.BoolValue()
+		case "assign-roles":
+			options.AssignRoles = option.BoolValue()
+		}
+	}
+
+	if attachment == nil {
+		return contentEdit("Please attach a CSV or JSON file."), nil
+	}
+	if attachment.Size > maxImportUploadSize {
+		return contentEdit(fmt.Sprintf("The import file must be smaller than %d MB.", maxImportUploadSize>>20)), nil
+	}
+
+	records, err := downloadImport(ctx, attachment)
+	if err != nil {
+		slog.Error("Failed to read import upload", "file", attachment.Filename, "error", err)
+		return contentEdit(fmt.Sprintf("Failed to read the import file: %v", err)), nil
+	}
+
+	slog.Info("Admin triggered import", "admin_id", i.Member.User.ID, "file", attachment.Filename, "rows", len(records), "dry_run", options.DryRun)
+	results := h.ImportUsers(records, options)
+
+	var report bytes.Buffer
+	if err := WriteImportReport(&report, results); err != nil {
+		slog.Error("Failed to write import report", "error", err)
+	}
+
+	summary := ImportSummary(results)
+	content := fmt.Sprintf("Import of %d rows: %d imported, %d skipped, %d failed.",
+		len(results), summary[ImportStatusImported], summary[ImportStatusSkipped], summary[ImportStatusFailed])
+	if options.DryRun {
+		content = fmt.Sprintf("Dry run of %d rows: %d would be imported, %d skipped, %d failed. Nothing was changed.",
+			len(results), summary[ImportStatusWouldImport], summary[ImportStatusSkipped], summary[ImportStatusFailed])
+	}
+
+	return &discordgo.WebhookEdit{
+		Content: &content,
+		Files: []*discordgo.File{
+			{
+				Name:        "import-report.csv",
+				ContentType: "text/csv",
+				Reader:      &report,
+			},
+		},
+	}, nil
+}
+
+// downloadImport fetches and parses an import file attached to an interaction
+func downloadImport(ctx context.Context, attachment *discordgo.MessageAttachment) ([]models.ImportRecord, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, attachment.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download attachment: %w", err)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download attachment: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download attachment: status %d", resp.StatusCode)
+	}
+
+	return models.ParseUserImport(io.LimitReader(resp.Body, maxImportUploadSize), models.ImportFormatFromName(attachment.Filename))
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shopwarelabs/discord-bot/models"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// baselineUsersSchema is the users table of releases before schema_migrations
+// existed, with azure_user_id declared UNIQUE NOT NULL
+const baselineUsersSchema = `
+	CREATE TABLE users (
+		user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		discord_id TEXT UNIQUE NOT NULL,
+		azure_user_id TEXT UNIQUE NOT NULL,
+		email TEXT NOT NULL,
+		name TEXT NOT NULL,
+		verified_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE verifications (
+		user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT UNIQUE NOT NULL,
+		discord_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+`
+
+func TestImportUsersWithoutAzureIDIntoUpgradedDatabase(t *testing.T) {
+	db, err := models.OpenDatabase(filepath.Join(t.TempDir(), "legacy.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if _, err := db.GetDB().Exec(baselineUsersSchema); err != nil {
+		t.Fatalf("failed to create baseline schema: %v", err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	store := models.NewVerificationStore(db, nil)
+	handler, err := NewDiscordHandler(&models.Config{AllowedDomains: []string{"example.com"}}, store)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	records, err := models.ParseUserImport(strings.NewReader(
+		"discord_id,email,azure_user_id,name\n"+
+			"123456789012345678,first@example.com,,First\n"+
+			"223456789012345678,second@example.com,azure-2,Second\n",
+	), models.ImportFormatCSV)
+	if err != nil {
+		t.Fatalf("failed to parse import: %v", err)
+	}
+
+	results := handler.ImportUsers(records, ImportOptions{})
+	for _, result := range results {
+		if result.Status != ImportStatusImported {
+			t.Fatalf("row %d: %s %s", result.Row, result.Status, result.Message)
+		}
+	}
+
+	user, ok := store.GetUser("123456789012345678")
+	if !ok || user.AzureUserID != "" || user.Email != "first@example.com" {
+		t.Fatalf("imported user without Azure ID = %+v, %v", user, ok)
+	}
+	if !store.IsUserVerifiedByAzureID("azure-2") {
+		t.Fatal("imported user with Azure ID is not verified")
+	}
+}
+
+func TestImportUsersCommandWithoutResolvedAttachment(t *testing.T) {
+	handler, err := NewDiscordHandler(&models.Config{}, models.NewMemoryStore())
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	interaction := &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		Type:   discordgo.InteractionApplicationCommand,
+		Member: &discordgo.Member{User: &discordgo.User{ID: "5"}, Permissions: discordgo.PermissionAdministrator},
+		Data: discordgo.ApplicationCommandInteractionData{
+			Name: "import-users",
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "file", Type: discordgo.ApplicationCommandOptionAttachment, Value: "123"},
+			},
+		},
+	}}
+
+	edit, err := handler.handleImportUsersCommand(context.Background(), nil, interaction)
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if edit.Content == nil || !strings.Contains(*edit.Content, "could not be read") {
+		t.Fatalf("unexpected reply %+v", edit)
+	}
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxInteractionBodySize limits interaction webhooks, real payloads are a few KB
+	maxInteractionBodySize = 1 << 20
+
+	// interactionResponseDeadline is how long Discord waits for the initial response
+	interactionResponseDeadline = 3 * time.Second
+
+	// maxInteractionAge rejects replayed webhooks with an old signature timestamp
+	maxInteractionAge = 5 * time.Minute
+)
+
+// Responder delivers interaction responses. *discordgo.Session answers gateway
+// interactions through the REST callback, interactions received as webhooks are
+// answered in the body of the HTTP response instead.
+type Responder interface {
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error
+	InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error)
+}
+
+// httpResponder hands the initial response of a webhook interaction back to the
+// HTTP handler. Edits and follow-ups use the interaction token over REST as usual.
+type httpResponder struct {
+	session   *discordgo.Session
+	once      sync.Once
+	responses chan *discordgo.InteractionResponse
+	// written is closed once the HTTP handler is done, delivered tells whether
+	// the response was written to Discord by then
+	written   chan struct{}
+	delivered atomic.Bool
+}
+
+func newHTTPResponder(session *discordgo.Session) *httpResponder {
+	return &httpResponder{
+		session: session,
+		// Buffered so a handler never blocks after the HTTP request gave up waiting
+		responses: make(chan *discordgo.InteractionResponse, 1),
+		written:   make(chan struct{}),
+	}
+}
+
+// InteractionRespond returns once the response has been sent to Discord, so
+// deferred handlers never edit a response Discord has not received yet
+func (r *httpResponder) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	sent := false
+	r.once.Do(func() {
+		r.responses <- resp
+		sent = true
+	})
+	if !sent {
+		return fmt.Errorf("interaction %s was already responded to", interaction.ID)
+	}
+
+	<-r.written
+	if !r.delivered.Load() {
+		return fmt.Errorf("response to interaction %s could not be delivered", interaction.ID)
+	}
+	return nil
+}
+
+func (r *httpResponder) InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return r.session.InteractionResponseEdit(interaction, newresp, options...)
+}
+
+func (r *httpResponder) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return r.session.FollowupMessageCreate(interaction, wait, data, options...)
+}
+
+// HandleInteractionWebhook serves /discord/interactions, Discord's outgoing webhook
+// alternative to the gateway. Requests must carry a valid Ed25519 signature of the
+// application public key, commands are dispatched to the same handlers as gateway
+// interactions.
+func (h *DiscordHandler) HandleInteractionWebhook(c *gin.Context) {
+	publicKey, err := hex.DecodeString(h.currentConfig().DiscordPublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxInteractionBodySize)
+	if !discordgo.VerifyInteraction(c.Request, publicKey) || !recentSignature(c.GetHeader("X-Signature-Timestamp")) {
+		slog.Warn("Rejected interaction webhook with invalid signature", "ip", c.ClientIP())
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid request signature"})
+		return
+	}
+
+	// VerifyInteraction puts the body it consumed back into the request
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var interaction discordgo.Interaction
+	if err := json.Unmarshal(body, &interaction); err != nil {
+		slog.Warn("Failed to decode interaction webhook", "error", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid interaction"})
+		return
+	}
+
+	if interaction.Type == discordgo.InteractionPing {
+		c.JSON(http.StatusOK, discordgo.InteractionResponse{Type: discordgo.InteractionResponsePong})
+		return
+	}
+
+	// The handler keeps running in the background for follow-ups after the response is sent
+	responder := newHTTPResponder(h.session)
+	defer close(responder.written)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.dispatchInteraction(responder, &discordgo.InteractionCreate{Interaction: &interaction})
+	}()
+
+	var resp *discordgo.InteractionResponse
+	select {
+	case resp = <-responder.responses:
+	case <-done:
+	case <-time.After(interactionResponseDeadline):
+	}
+
+	// The handler may have responded right before returning
+	if resp == nil {
+		select {
+		case resp = <-responder.responses:
+		default:
+			slog.Error("Interaction webhook was not answered", "interaction_id", interaction.ID, "type", interaction.Type)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := writeInteractionResponse(c, resp); err != nil {
+		slog.Error("Failed to write interaction response", "interaction_id", interaction.ID, "error", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	responder.delivered.Store(true)
+}
+
+// writeInteractionResponse writes the response and flushes it to Discord. Files are
+// not part of the JSON encoding, responses carrying them are sent as multipart
+// like the REST callback does.
+func writeInteractionResponse(c *gin.Context, resp *discordgo.InteractionResponse) error {
+	if resp.Data != nil && len(resp.Data.Files) > 0 {
+		contentType, body, err := discordgo.MultipartBodyWithJSON(resp, resp.Data.Files)
+		if err != nil {
+			return fmt.Errorf("failed to encode multipart response: %w", err)
+		}
+		c.Data(http.StatusOK, contentType, body)
+	} else {
+		c.JSON(http.StatusOK, resp)
+	}
+
+	c.Writer.Flush()
+	return nil
+}
+
+// recentSignature reports whether a signature timestamp in Unix seconds is fresh enough
+func recentSignature(timestamp string) bool {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := time.Since(time.Unix(seconds, 0))
+	return age < maxInteractionAge && age > -maxInteractionAge
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shopwarelabs/discord-bot/models"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/gin-gonic/gin"
+)
+
+// signedInteraction builds a webhook request signed like Discord does
+func signedInteraction(t *testing.T, key ed25519.PrivateKey, body string) *http.Request {
+	t.Helper()
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := ed25519.Sign(key, []byte(timestamp+body))
+
+	request := httptest.NewRequest(http.MethodPost, "/discord/interactions", bytes.NewBufferString(body))
+	request.Header.Set("X-Signature-Ed25519", hex.EncodeToString(signature))
+	request.Header.Set("X-Signature-Timestamp", timestamp)
+	return request
+}
+
+// readMultipart returns the parts of a multipart/form-data body by form name
+func readMultipart(t *testing.T, contentType string, body io.Reader) map[string][]byte {
+	t.Helper()
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		t.Fatalf("expected a multipart body, got %q", contentType)
+	}
+
+	parts := map[string][]byte{}
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatalf("failed to read part: %v", err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("failed to read part: %v", err)
+		}
+		parts[part.FormName()] = data
+	}
+}
+
+func TestWriteInteractionResponseSendsFilesAsMultipart(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	err := writeInteractionResponse(c, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "export",
+			Files:   []*discordgo.File{{Name: "export.json", ContentType: "application/json", Reader: bytes.NewBufferString(`{"ok":true}`)}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to write response: %v", err)
+	}
+
+	parts := readMultipart(t, recorder.Header().Get("Content-Type"), recorder.Body)
+
+	var resp discordgo.InteractionResponse
+	if err := json.Unmarshal(parts["payload_json"], &resp); err != nil {
+		t.Fatalf("invalid payload_json: %v", err)
+	}
+	if resp.Type != discordgo.InteractionResponseChannelMessageWithSource || resp.Data.Content != "export" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if string(parts["files[0]"]) != `{"ok":true}` {
+		t.Fatalf("unexpected attachment %q", parts["files[0]"])
+	}
+}
+
+// roundTripperFunc stands in for the Discord REST API
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+func TestExportUserDataIsDeferred(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	store := models.NewMemoryStore()
+	if err := store.CreateUser("700", "user@example.com", "User"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	handler, err := NewDiscordHandler(&models.Config{DiscordPublicKey: hex.EncodeToString(publicKey)}, store)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	type edit struct {
+		method, contentType string
+		body                []byte
+	}
+	edits := make(chan edit, 1)
+	handler.session.Client = &http.Client{Transport: roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			return nil, err
+		}
+		edits <- edit{method: request.Method, contentType: request.Header.Get("Content-Type"), body: body}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(bytes.NewBufferString(`{"id": "10"}`)),
+			Request:    request,
+		}, nil
+	})}
+
+	router := gin.New()
+	router.POST("/discord/interactions", handler.HandleInteractionWebhook)
+
+	body := `{
+		"id": "1", "application_id": "2", "type": 2, "token": "token", "guild_id": "3", "channel_id": "4",
+		"member": {"user": {"id": "5"}, "permissions": "8"},
+		"data": {"id": "6", "name": "export-user-data", "type": 1, "options": [{"name": "user", "type": 6, "value": "700"}]}
+	}`
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, signedInteraction(t, privateKey, body))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", recorder.Code, recorder.Body.String())
+	}
+
+	var ack discordgo.InteractionResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &ack); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if ack.Type != discordgo.InteractionResponseDeferredChannelMessageWithSource {
+		t.Fatalf("export was not deferred, response type %d", ack.Type)
+	}
+
+	var sent edit
+	select {
+	case sent = <-edits:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deferred response was not edited")
+	}
+	if sent.method != http.MethodPatch {
+		t.Fatalf("unexpected edit method %s", sent.method)
+	}
+
+	parts := readMultipart(t, sent.contentType, bytes.NewReader(sent.body))
+	var export models.UserDataExport
+	if err := json.Unmarshal(parts["files[0]"], &export); err != nil {
+		t.Fatalf("attachment is not the JSON export: %v", err)
+	}
+	if export.User == nil || export.User.Email != "user@example.com" {
+		t.Fatalf("unexpected export: %+v", export)
+	}
+}
+
+func TestHTTPResponderWaitsForDelivery(t *testing.T) {
+	responder := newHTTPResponder(nil)
+	interaction := &discordgo.Interaction{ID: "1"}
+
+	returned := make(chan error, 1)
+	go func() {
+		returned <- responder.InteractionRespond(interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		})
+	}()
+
+	<-responder.responses
+	select {
+	case <-returned:
+		t.Fatal("InteractionRespond returned before the response was written")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	responder.delivered.Store(true)
+	close(responder.written)
+	if err := <-returned; err != nil {
+		t.Fatalf("InteractionRespond failed: %v", err)
+	}
+}
@@ -3,10 +3,15 @@ package handlers
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/shopwarelabs/discord-bot/models"
 
@@ -18,14 +23,34 @@ import (
 )
 
 type OAuthHandler struct {
-	config         *models.Config
-	store          *models.VerificationStore
+	config         atomic.Pointer[models.Config]
+	store          models.Storage
+	flows          *models.FlowStore
 	oauthConfig    *oauth2.Config
 	discordHandler *DiscordHandler
 	verifier       *oidc.IDTokenVerifier
+	issuerURL      string
+
+	providerMu        sync.Mutex
+	providerCheckedAt time.Time
+	providerErr       error
 }
 
-func NewOAuthHandler(config *models.Config, store *models.VerificationStore, discordHandler *DiscordHandler) (*OAuthHandler, error) {
+const (
+	// providerCheckTTL is how long the result of an OIDC reachability check is cached
+	providerCheckTTL = time.Minute
+
+	// maxIDTokenAge is how old an ID token's iat may be when it reaches the callback
+	maxIDTokenAge = 10 * time.Minute
+
+	// maxAuthAge is how long ago the user may have signed in at Microsoft, sent as max_age
+	maxAuthAge = 24 * time.Hour
+
+	// allowedClockSkew tolerates small clock differences with the identity provider
+	allowedClockSkew = time.Minute
+)
+
+func NewOAuthHandler(config *models.Config, store models.Storage, flows *models.FlowStore, discordHandler *DiscordHandler) (*OAuthHandler, error) {
 	ctx := context.Background()
 	issuerURL := fmt.Sprintf("https://login.microsoftonline.com/%s/v2.0", config.MicrosoftTenantID)
 	provider, err := oidc.NewProvider(ctx, issuerURL)
@@ -45,22 +70,111 @@ func NewOAuthHandler(config *models.Config, store *models.VerificationStore, dis
 		ClientID: config.MicrosoftClientID,
 	})
 
-	return &OAuthHandler{
-		config:         config,
+	handler := &OAuthHandler{
 		store:          store,
+		flows:          flows,
 		oauthConfig:    oauthConfig,
 		discordHandler: discordHandler,
 		verifier:       verifier,
-	}, nil
+		issuerURL:      issuerURL,
+	}
+	handler.config.Store(config)
+
+	return handler, nil
+}
+
+// ApplyConfig atomically replaces the configuration used for new requests.
+// Settings baked into the OAuth client (client ID, secret, tenant, redirect URL) require a restart.
+func (h *OAuthHandler) ApplyConfig(config *models.Config) {
+	h.config.Store(config)
+}
+
+// HealthCheck reports whether the OIDC provider's discovery document is reachable.
+// Results are cached so probes don't hammer the identity provider.
+func (h *OAuthHandler) HealthCheck(ctx context.Context) ComponentStatus {
+	if h == nil {
+		return componentDown("oauth handler not initialized", nil)
+	}
+
+	h.providerMu.Lock()
+	defer h.providerMu.Unlock()
+
+	if time.Since(h.providerCheckedAt) > providerCheckTTL {
+		h.providerErr = h.checkProvider(ctx)
+		h.providerCheckedAt = time.Now()
+	}
+
+	details := map[string]any{
+		"issuer":     h.issuerURL,
+		"checked_at": h.providerCheckedAt.UTC().Format(time.RFC3339),
+	}
+
+	if h.providerErr != nil {
+		return componentDown(h.providerErr.Error(), details)
+	}
+
+	return componentUp(details)
 }
 
+func (h *OAuthHandler) checkProvider(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.issuerURL+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return fmt.Errorf("failed to build discovery request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach OIDC provider: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OIDC discovery returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// discordIDContextKey holds the Discord ID resolved by ResolveVerificationCode
+const discordIDContextKey = "discord_id"
+
+// ResolveVerificationCode looks up the Discord user a verification link was issued
+// to and stores the ID in the context for the rate limiter and StartAuth
+func (h *OAuthHandler) ResolveVerificationCode(c *gin.Context) {
+	code := c.Query("code")
+	verification, ok := h.store.Get(code)
+	if code == "" || !ok {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{
+			"error": "This verification link is invalid or has expired, please request a new one in Discord",
+		})
+		c.Abort()
+		return
+	}
+
+	c.Set(discordIDContextKey, verification.DiscordID)
+	c.Next()
+}
+
+// StartAuth redirects to Microsoft for the user resolved by ResolveVerificationCode.
+// The verification code is consumed first, so every link starts a single flow.
 func (h *OAuthHandler) StartAuth(c *gin.Context) {
-	discordID := c.Query("state")
+	discordID := c.GetString(discordIDContextKey)
 	if discordID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing Discord ID"})
 		return
 	}
 
+	// Only one request can consume the code, a concurrent or repeated one is rejected
+	verification, ok := h.store.Consume(c.Query("code"))
+	if !ok || verification.DiscordID != discordID {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{
+			"error": "This verification link is invalid or has expired, please request a new one in Discord",
+		})
+		return
+	}
+
 	state, err := generateSecureState()
 	if err != nil {
 		slog.Error("Failed to generate state", "error", err)
@@ -68,20 +182,68 @@ func (h *OAuthHandler) StartAuth(c *gin.Context) {
 		return
 	}
 
-	session := sessions.Default(c)
-	session.Set("discord_id_"+state, discordID)
-	session.Set("oauth_state", state)
-	if err := session.Save(); err != nil {
+	nonce, err := generateSecureState()
+	if err != nil {
+		slog.Error("Failed to generate nonce", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate nonce"})
+		return
+	}
+
+	browserID, err := h.browserID(c)
+	if err != nil {
 		slog.Error("Failed to save session", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Session error"})
 		return
 	}
 
-	// Redirect to OAuth provider with secure state
-	authURL := h.oauthConfig.AuthCodeURL(state)
+	codeVerifier := oauth2.GenerateVerifier()
+
+	err = h.flows.Create(&models.OAuthFlow{
+		State:        state,
+		BrowserID:    browserID,
+		DiscordID:    discordID,
+		CodeVerifier: codeVerifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().Add(models.OAuthFlowTTL),
+	})
+	if err != nil {
+		slog.Error("Failed to store OAuth flow", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Session error"})
+		return
+	}
+
+	// Redirect to OAuth provider with secure state, PKCE challenge and nonce
+	authURL := h.oauthConfig.AuthCodeURL(state,
+		oauth2.S256ChallengeOption(codeVerifier),
+		oidc.Nonce(nonce),
+		oauth2.SetAuthURLParam("max_age", strconv.Itoa(int(maxAuthAge.Seconds()))),
+	)
 	c.Redirect(http.StatusTemporaryRedirect, authURL)
 }
 
+// browserIDKey is the session key binding pending OAuth flows to a browser
+const browserIDKey = "browser_id"
+
+// browserID returns the random ID identifying this browser's session, creating it if needed
+func (h *OAuthHandler) browserID(c *gin.Context) (string, error) {
+	session := sessions.Default(c)
+	if browserID, ok := session.Get(browserIDKey).(string); ok && browserID != "" {
+		return browserID, nil
+	}
+
+	browserID, err := generateSecureState()
+	if err != nil {
+		return "", err
+	}
+
+	session.Set(browserIDKey, browserID)
+	if err := session.Save(); err != nil {
+		return "", err
+	}
+
+	return browserID, nil
+}
+
 // generateSecureState generates a cryptographically secure random state parameter
 func generateSecureState() (string, error) {
 	bytes := make([]byte, 32)
@@ -92,6 +254,32 @@ func generateSecureState() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
+// checkTokenFreshness rejects ID tokens that were issued too long ago or for
+// a sign-in that is older than maxAuthAge, so replayed tokens can't be used
+func checkTokenFreshness(issuedAt time.Time, authTime int64, now time.Time) error {
+	if issuedAt.IsZero() {
+		return fmt.Errorf("missing iat claim")
+	}
+	if issuedAt.After(now.Add(allowedClockSkew)) {
+		return fmt.Errorf("iat %s is in the future", issuedAt)
+	}
+	if now.Sub(issuedAt) > maxIDTokenAge {
+		return fmt.Errorf("iat %s is older than %s", issuedAt, maxIDTokenAge)
+	}
+
+	if authTime != 0 {
+		authenticatedAt := time.Unix(authTime, 0)
+		if authenticatedAt.After(now.Add(allowedClockSkew)) {
+			return fmt.Errorf("auth_time %s is in the future", authenticatedAt)
+		}
+		if now.Sub(authenticatedAt) > maxAuthAge+allowedClockSkew {
+			return fmt.Errorf("auth_time %s is older than %s", authenticatedAt, maxAuthAge)
+		}
+	}
+
+	return nil
+}
+
 // Callback handles the OAuth callback
 func (h *OAuthHandler) Callback(c *gin.Context) {
 	code := c.Query("code")
@@ -105,46 +293,40 @@ func (h *OAuthHandler) Callback(c *gin.Context) {
 	}
 
 	if state == "" {
+		countFailure(c)
 		c.HTML(http.StatusBadRequest, "error.html", gin.H{
 			"error": "Missing state parameter",
 		})
 		return
 	}
 
-	session := sessions.Default(c)
-	sessionState := session.Get("oauth_state")
-	if sessionState == nil || sessionState.(string) != state {
-		slog.Error("Invalid state parameter", "received", state, "expected", sessionState)
+	flow, ok := h.flows.Consume(state)
+	if !ok {
+		slog.Error("Unknown or expired state parameter", "received", state)
+		countFailure(c)
 		c.HTML(http.StatusBadRequest, "error.html", gin.H{
 			"error": "Invalid state parameter",
 		})
 		return
 	}
 
-	discordIDKey := "discord_id_" + state
-	discordIDValue := session.Get(discordIDKey)
-	if discordIDValue == nil {
-		slog.Error("Discord ID not found in session", "state", state)
+	// The flow must complete in the browser that started it
+	browserID, _ := sessions.Default(c).Get(browserIDKey).(string)
+	if browserID == "" || subtle.ConstantTimeCompare([]byte(browserID), []byte(flow.BrowserID)) != 1 {
+		slog.Error("OAuth flow started in a different browser", "state", state)
+		countFailure(c)
 		c.HTML(http.StatusBadRequest, "error.html", gin.H{
 			"error": "Session expired or invalid",
 		})
 		return
 	}
 
-	discordID := discordIDValue.(string)
-
-	session.Delete("oauth_state")
-	session.Delete(discordIDKey)
-	if err := session.Save(); err != nil {
-		slog.Error("Failed to save session", "error", err)
-		c.HTML(http.StatusInternalServerError, "error.html", gin.H{
-			"error": "Failed to save session",
-		})
-		return
-	}
+	discordID := flow.DiscordID
+	codeVerifier := flow.CodeVerifier
+	nonce := flow.Nonce
 
 	ctx := context.Background()
-	token, err := h.oauthConfig.Exchange(ctx, code)
+	token, err := h.oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
 	if err != nil {
 		slog.Error("Failed to exchange code for token", "error", err)
 		c.HTML(http.StatusInternalServerError, "error.html", gin.H{
@@ -179,11 +361,21 @@ func (h *OAuthHandler) Callback(c *gin.Context) {
 		return
 	}
 
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		slog.Error("ID token nonce mismatch", "state", state)
+		countFailure(c)
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{
+			"error": "Invalid authentication response",
+		})
+		return
+	}
+
 	var claims struct {
 		Sub               string `json:"sub"`
 		Email             string `json:"email"`
 		PreferredUsername string `json:"preferred_username"`
 		UPN               string `json:"upn"`
+		AuthTime          int64  `json:"auth_time"`
 	}
 	if err := idToken.Claims(&claims); err != nil {
 		slog.Error("Failed to parse ID token claims", "error", err)
@@ -193,6 +385,14 @@ func (h *OAuthHandler) Callback(c *gin.Context) {
 		return
 	}
 
+	if err := checkTokenFreshness(idToken.IssuedAt, claims.AuthTime, time.Now()); err != nil {
+		slog.Error("Stale ID token", "error", err)
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{
+			"error": "Authentication response expired, please try again",
+		})
+		return
+	}
+
 	if claims.Sub == "" {
 		slog.Error("No subject (user ID) found in ID token", "claims", claims)
 		c.HTML(http.StatusInternalServerError, "error.html", gin.H{
@@ -217,7 +417,22 @@ func (h *OAuthHandler) Callback(c *gin.Context) {
 		return
 	}
 
-	err = h.discordHandler.VerifyUserDirectly(discordID, claims.Sub, email)
+	h.completeVerification(c, discordID, claims.Sub, email)
+}
+
+// completeVerification verifies the signed-in Microsoft account for discordID.
+// The allowed domains are read from the current configuration, so a reload
+// applies to the next callback.
+func (h *OAuthHandler) completeVerification(c *gin.Context, discordID, azureUserID, email string) {
+	if !h.config.Load().IsEmailAllowed(email) {
+		h.discordHandler.recordAuditEvent(discordID, models.AuditVerificationFailed, "email domain not allowed")
+		c.HTML(http.StatusForbidden, "error.html", gin.H{
+			"error": "Your email domain is not allowed to verify",
+		})
+		return
+	}
+
+	err := h.discordHandler.VerifyUserDirectly(discordID, azureUserID, email)
 	if err != nil {
 		slog.Error("Failed to verify user", "error", err)
 		c.HTML(http.StatusInternalServerError, "error.html", gin.H{
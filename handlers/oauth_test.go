@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/shopwarelabs/discord-bot/models"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+func TestStartAuthRequiresIssuedVerificationCode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := models.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	config := &models.Config{BaseURL: "https://verify.example.com"}
+	store := models.NewVerificationStore(db, nil)
+	discordHandler, err := NewDiscordHandler(config, store)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	flows := models.NewFlowStore(db)
+	oauthHandler := &OAuthHandler{
+		store: store,
+		flows: flows,
+		oauthConfig: &oauth2.Config{
+			ClientID: "client",
+			Endpoint: oauth2.Endpoint{AuthURL: "https://login.example.com/authorize"},
+		},
+	}
+	oauthHandler.config.Store(config)
+
+	var resolved []string
+	router := gin.New()
+	router.LoadHTMLGlob("../templates/*")
+	router.Use(sessions.Sessions("test", cookie.NewStore([]byte("secret"))))
+	router.GET("/employee/start", oauthHandler.ResolveVerificationCode, func(c *gin.Context) {
+		resolved = append(resolved, DiscordIDKey(c))
+	}, oauthHandler.StartAuth)
+
+	start := func(query string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/employee/start?"+query, nil))
+		return recorder
+	}
+
+	// Links with a raw Discord ID are not accepted anymore
+	if code := start("state=123456789012345678").Code; code != http.StatusBadRequest {
+		t.Fatalf("forged link got status %d", code)
+	}
+	if code := start("code=unknown").Code; code != http.StatusBadRequest {
+		t.Fatalf("unknown code got status %d", code)
+	}
+
+	prompt, err := discordHandler.verifyPrompt(config, "123456789012345678", "")
+	if err != nil {
+		t.Fatalf("failed to build prompt: %v", err)
+	}
+	link, err := url.Parse(promptLink(t, prompt))
+	if err != nil {
+		t.Fatalf("invalid verification link: %v", err)
+	}
+	if link.Query().Get("state") != "" || link.Query().Get("code") == "" {
+		t.Fatalf("link does not carry a verification code: %s", link)
+	}
+
+	recorder := start(link.RawQuery)
+	if recorder.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("issued code got status %d", recorder.Code)
+	}
+	if len(resolved) != 1 || resolved[0] != "123456789012345678" {
+		t.Fatalf("rate limit keys = %v", resolved)
+	}
+
+	redirect, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect: %v", err)
+	}
+	flow, ok := flows.Consume(redirect.Query().Get("state"))
+	if !ok || flow.DiscordID != "123456789012345678" {
+		t.Fatalf("flow = %+v, %v", flow, ok)
+	}
+
+	// Codes are single-use
+	if code := start(link.RawQuery).Code; code != http.StatusBadRequest {
+		t.Fatalf("reused code got status %d", code)
+	}
+}
+
+func TestCallbackUsesReloadedAllowedDomains(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	config := &models.Config{AllowedDomains: []string{"example.com"}}
+	store := models.NewMemoryStore()
+	discordHandler, err := NewDiscordHandler(config, store)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	oauthHandler := &OAuthHandler{store: store, discordHandler: discordHandler}
+	oauthHandler.config.Store(config)
+
+	// The Microsoft account is linked already, so an allowed domain stops there
+	// instead of reaching Discord
+	if err := store.CreateUserWithAzureID("222", "azure-111", "other@example.org", "Other"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	router := gin.New()
+	router.LoadHTMLGlob("../templates/*")
+	router.GET("/callback", func(c *gin.Context) {
+		oauthHandler.completeVerification(c, "111", "azure-111", "first@example.org")
+	})
+	callback := func() int {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/callback", nil))
+		return recorder.Code
+	}
+
+	if code := callback(); code != http.StatusForbidden {
+		t.Fatalf("domain not allowed got status %d", code)
+	}
+
+	reloaded := *config
+	reloaded.AllowedDomains = []string{"example.com", "example.org"}
+	oauthHandler.ApplyConfig(&reloaded)
+
+	if code := callback(); code == http.StatusForbidden {
+		t.Fatal("domain allowed by the reload is still rejected")
+	}
+}
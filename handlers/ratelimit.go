@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shopwarelabs/discord-bot/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// limiterPruneInterval is how often idle rate limit entries are dropped from memory
+const limiterPruneInterval = time.Minute
+
+// limiterStates holds per-key rate limit state in memory, optionally persisted to the database
+type limiterStates struct {
+	name   string
+	store  *models.RateLimitStore
+	mu     sync.Mutex
+	states map[string]*models.RateLimitState
+}
+
+func newLimiterStates(name string, store *models.RateLimitStore) *limiterStates {
+	states := &limiterStates{
+		name:   name,
+		store:  store,
+		states: make(map[string]*models.RateLimitState),
+	}
+
+	go states.prune()
+
+	return states
+}
+
+// get returns the state for key, loading it from the store if it isn't cached. Callers must hold mu.
+func (l *limiterStates) get(key string, now time.Time) (*models.RateLimitState, bool) {
+	if state, ok := l.states[key]; ok && now.Before(state.ExpiresAt) {
+		return state, true
+	}
+
+	if l.store != nil {
+		if state, ok := l.store.Get(l.name + ":" + key); ok {
+			l.states[key] = state
+			return state, true
+		}
+	}
+
+	return nil, false
+}
+
+// put caches and persists the state. Callers must hold mu.
+func (l *limiterStates) put(key string, state *models.RateLimitState) {
+	l.states[key] = state
+
+	if l.store != nil {
+		persisted := *state
+		persisted.Key = l.name + ":" + key
+		if err := l.store.Save(&persisted); err != nil {
+			slog.Error("Failed to persist rate limit state", "limiter", l.name, "error", err)
+		}
+	}
+}
+
+// remove drops the state for key. Callers must hold mu.
+func (l *limiterStates) remove(key string) {
+	delete(l.states, key)
+
+	if l.store != nil {
+		if err := l.store.Delete(l.name + ":" + key); err != nil {
+			slog.Error("Failed to delete rate limit state", "limiter", l.name, "error", err)
+		}
+	}
+}
+
+func (l *limiterStates) prune() {
+	ticker := time.NewTicker(limiterPruneInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		l.mu.Lock()
+		for key, state := range l.states {
+			if now.After(state.ExpiresAt) {
+				delete(l.states, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// RateLimiter is a token bucket limiter keyed by e.g. client IP or Discord ID
+type RateLimiter struct {
+	*limiterStates
+	interval time.Duration
+	burst    float64
+}
+
+// NewRateLimiter allows burst requests at once and refills one token every interval.
+// store is optional and persists buckets across restarts.
+func NewRateLimiter(name string, interval time.Duration, burst int, store *models.RateLimitStore) *RateLimiter {
+	return &RateLimiter{
+		limiterStates: newLimiterStates(name, store),
+		interval:      interval,
+		burst:         float64(burst),
+	}
+}
+
+// NewPerMinuteRateLimiter allows perMinute requests per minute with the given burst
+func NewPerMinuteRateLimiter(name string, perMinute, burst int, store *models.RateLimitStore) *RateLimiter {
+	return NewRateLimiter(name, time.Minute/time.Duration(perMinute), burst, store)
+}
+
+// Allow takes a token for key and reports whether the request may proceed.
+// If not, it returns how long until the next token is available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	state, ok := l.get(key, now)
+	if !ok {
+		state = &models.RateLimitState{Tokens: l.burst, UpdatedAt: now}
+	}
+
+	refilled := float64(now.Sub(state.UpdatedAt)) / float64(l.interval)
+	state.Tokens = math.Min(l.burst, state.Tokens+refilled)
+	state.UpdatedAt = now
+
+	allowed := state.Tokens >= 1
+	var retryAfter time.Duration
+	if allowed {
+		state.Tokens--
+	} else {
+		retryAfter = time.Duration((1 - state.Tokens) * float64(l.interval))
+	}
+
+	// The bucket is irrelevant once it would be full again
+	state.ExpiresAt = now.Add(time.Duration((l.burst - state.Tokens) * float64(l.interval)))
+	l.put(key, state)
+
+	return allowed, retryAfter
+}
+
+// Lockout blocks a key for a while after too many consecutive failures
+type Lockout struct {
+	*limiterStates
+	maxFailures int
+	duration    time.Duration
+}
+
+// NewLockout locks a key for duration once maxFailures failures were recorded within duration
+func NewLockout(name string, maxFailures int, duration time.Duration, store *models.RateLimitStore) *Lockout {
+	return &Lockout{
+		limiterStates: newLimiterStates(name, store),
+		maxFailures:   maxFailures,
+		duration:      duration,
+	}
+}
+
+// Locked reports whether key is locked out and for how much longer
+func (l *Lockout) Locked(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	state, ok := l.get(key, now)
+	if !ok || !now.Before(state.LockedUntil) {
+		return false, 0
+	}
+
+	return true, state.LockedUntil.Sub(now)
+}
+
+// Failure records a failed attempt and locks the key once the limit is reached
+func (l *Lockout) Failure(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	state, ok := l.get(key, now)
+	if !ok {
+		state = &models.RateLimitState{}
+	}
+
+	state.Failures++
+	state.UpdatedAt = now
+	state.ExpiresAt = now.Add(l.duration)
+	if state.Failures >= l.maxFailures {
+		state.LockedUntil = now.Add(l.duration)
+		slog.Warn("Locking out client after repeated failures", "limiter", l.name, "key", key, "failures", state.Failures)
+	}
+
+	l.put(key, state)
+}
+
+// Success clears the failure count for key
+func (l *Lockout) Success(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.get(key, time.Now()); ok {
+		l.remove(key)
+	}
+}
+
+// ClientIPKey keys rate limits by the client IP, honouring trusted proxies
+func ClientIPKey(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// DiscordIDKey keys rate limits by the Discord ID a verification link was issued
+// to, it must run after OAuthHandler.ResolveVerificationCode
+func DiscordIDKey(c *gin.Context) string {
+	return c.GetString(discordIDContextKey)
+}
+
+// RateLimit rejects requests once the bucket for the request's key is empty
+func RateLimit(limiter *RateLimiter, keyFunc func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := keyFunc(c)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		if allowed, retryAfter := limiter.Allow(key); !allowed {
+			slog.Warn("Rate limit exceeded", "limiter", limiter.name, "key", key, "path", c.Request.URL.Path)
+			tooManyRequests(c, retryAfter)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Context keys set by handlers for the middlewares in this file
+const (
+	lockoutFailureKey = "lockout_failure"
+	jsonErrorsKey     = "json_errors"
+)
+
+// FailureLockout blocks clients whose requests keep failing, e.g. forged or replayed
+// callbacks. Only requests the handler marked with countFailure are counted, so
+// upstream errors or users who are already verified never lock out a shared IP.
+func FailureLockout(lockout *Lockout, keyFunc func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := keyFunc(c)
+
+		if locked, retryAfter := lockout.Locked(key); locked {
+			tooManyRequests(c, retryAfter)
+			return
+		}
+
+		c.Next()
+
+		switch {
+		case c.GetBool(lockoutFailureKey):
+			lockout.Failure(key)
+		case c.Writer.Status() < http.StatusBadRequest:
+			lockout.Success(key)
+		}
+	}
+}
+
+// countFailure makes FailureLockout count the request against the client
+func countFailure(c *gin.Context) {
+	c.Set(lockoutFailureKey, true)
+}
+
+// JSONErrors makes the middlewares in this file answer with JSON instead of the
+// HTML error page, for API routes
+func JSONErrors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(jsonErrorsKey, true)
+		c.Next()
+	}
+}
+
+func tooManyRequests(c *gin.Context, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	message := fmt.Sprintf("Too many requests, please try again in %d seconds", seconds)
+
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	if c.GetBool(jsonErrorsKey) {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": message})
+		return
+	}
+	c.HTML(http.StatusTooManyRequests, "error.html", gin.H{
+		"error": message,
+	})
+	c.Abort()
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFailureLockoutCountsOnlyMarkedFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	lockout := NewLockout("test", 2, time.Minute, nil)
+	router := gin.New()
+	router.Use(JSONErrors(), FailureLockout(lockout, ClientIPKey))
+	router.GET("/upstream-error", func(c *gin.Context) {
+		c.Status(http.StatusInternalServerError)
+	})
+	router.GET("/rejected", func(c *gin.Context) {
+		countFailure(c)
+		c.Status(http.StatusBadRequest)
+	})
+
+	request := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+
+	for range 5 {
+		if code := request("/upstream-error").Code; code != http.StatusInternalServerError {
+			t.Fatalf("upstream errors locked the client out, got %d", code)
+		}
+	}
+
+	request("/rejected")
+	request("/rejected")
+
+	recorder := request("/upstream-error")
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected lockout after rejected requests, got %d", recorder.Code)
+	}
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("expected a JSON lockout response, got %q", recorder.Header().Get("Content-Type"))
+	}
+	if recorder.Header().Get("Retry-After") == "" {
+		t.Fatal("missing Retry-After header")
+	}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/shopwarelabs/discord-bot/models"
+)
+
+// guildMembersPageSize is the maximum page size of the list guild members endpoint
+const guildMembersPageSize = 1000
+
+// RoleChange is a role grant or removal planned by Reconcile
+type RoleChange struct {
+	GuildID   string `json:"guild_id"`
+	DiscordID string `json:"discord_id"`
+	Username  string `json:"username"`
+	RoleID    string `json:"role_id"`
+	Add       bool   `json:"add"`
+	Reason    string `json:"reason"`
+}
+
+func (c RoleChange) String() string {
+	action := "remove"
+	if c.Add {
+		action = "add"
+	}
+	return fmt.Sprintf("%s role %s in guild %s for %s (%s): %s", action, c.RoleID, c.GuildID, c.Username, c.DiscordID, c.Reason)
+}
+
+// Reconcile compares the roles of members in every configured guild with the
+// verification records. Verified members missing a role get it, members holding
+// a managed role without a matching verification lose it. With dryRun the
+// changes are only returned.
+func (h *DiscordHandler) Reconcile(dryRun bool) ([]RoleChange, error) {
+	config := h.currentConfig()
+
+	verified := make(map[string]*models.User)
+	err := h.store.ForEachUser(models.UserFilter{}, func(user *models.User) error {
+		verified[user.DiscordID] = user
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []RoleChange
+	for _, guild := range config.AllGuilds() {
+		guildChanges, err := h.guildRoleChanges(guild, verified)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, guildChanges...)
+	}
+
+	if dryRun {
+		return changes, nil
+	}
+
+	var errs []error
+	for _, change := range changes {
+		var err error
+		if change.Add {
+			err = h.session.GuildMemberRoleAdd(change.GuildID, change.DiscordID, change.RoleID)
+		} else {
+			err = h.session.GuildMemberRoleRemove(change.GuildID, change.DiscordID, change.RoleID)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", change, err))
+			continue
+		}
+		slog.Info("Reconciled role", "change", change.String())
+	}
+
+	return changes, errors.Join(errs...)
+}
+
+// guildRoleChanges pages through the members of a guild and plans the role changes
+func (h *DiscordHandler) guildRoleChanges(guild models.GuildConfig, verified map[string]*models.User) ([]RoleChange, error) {
+	managed := guild.ManagedRoleIDs()
+
+	var changes []RoleChange
+	after := ""
+	for {
+		members, err := h.session.GuildMembers(guild.ID, after, guildMembersPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list members of guild %s: %w", guild.ID, err)
+		}
+
+		for _, member := range members {
+			if member.User == nil || member.User.Bot {
+				continue
+			}
+
+			expected := make(map[string]bool)
+			reason := "not verified"
+			if user, ok := verified[member.User.ID]; ok {
+				for _, roleID := range guild.RoleIDsForEmail(user.Email) {
+					expected[roleID] = true
+				}
+				reason = "verified as " + user.Email
+				if len(expected) == 0 {
+					reason += ", domain not allowed in this guild"
+				}
+			}
+
+			change := RoleChange{GuildID: guild.ID, DiscordID: member.User.ID, Username: member.User.Username, Reason: reason}
+			held := make(map[string]bool, len(member.Roles))
+			for _, roleID := range member.Roles {
+				held[roleID] = true
+				if managed[roleID] && !expected[roleID] {
+					change.RoleID = roleID
+					changes = append(changes, change)
+				}
+			}
+			for roleID := range expected {
+				if !held[roleID] {
+					change.RoleID = roleID
+					change.Add = true
+					changes = append(changes, change)
+				}
+			}
+		}
+
+		if len(members) < guildMembersPageSize {
+			break
+		}
+		after = members[len(members)-1].User.ID
+	}
+
+	return changes, nil
+}
+
+// RevokeVerification revokes a user's verification and removes the roles it granted
+func (h *DiscordHandler) RevokeVerification(discordID string) error {
+	config := h.currentConfig()
+
+	user, ok := h.store.GetUser(discordID)
+	if !ok {
+		return fmt.Errorf("no verified user with Discord ID %s", discordID)
+	}
+
+	if err := h.store.RevokeUser(discordID); err != nil {
+		return err
+	}
+
+	err := h.removeRoles(config, discordID)
+	h.logToGuilds(config.AllGuilds(), fmt.Sprintf("<@%s> (%s) verification revoked", discordID, user.Name))
+
+	slog.Info("Verification revoked", "discord_id", discordID)
+	return err
+}
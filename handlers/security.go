@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// contentSecurityPolicy only allows the inline styles used by the templates
+	contentSecurityPolicy = "default-src 'none'; style-src 'unsafe-inline'; img-src 'self' data:; form-action 'none'; frame-ancestors 'none'; base-uri 'none'"
+
+	hstsHeader = "max-age=31536000; includeSubDomains"
+)
+
+// SecurityHeaders adds browser security headers to every response.
+// HSTS is only sent for HTTPS requests, either terminated here or at a trusted proxy.
+func SecurityHeaders(trustedProxies []string) (gin.HandlerFunc, error) {
+	networks, err := parseNetworks(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		header.Set("Content-Security-Policy", contentSecurityPolicy)
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "no-referrer")
+
+		if isHTTPS(c, networks) {
+			header.Set("Strict-Transport-Security", hstsHeader)
+		}
+
+		c.Next()
+	}, nil
+}
+
+// isHTTPS reports whether the client connected via HTTPS. X-Forwarded-Proto is
+// only honoured when the request comes from a trusted proxy such as Traefik.
+func isHTTPS(c *gin.Context, trustedProxies []*net.IPNet) bool {
+	if c.Request.TLS != nil {
+		return true
+	}
+
+	proto := c.GetHeader("X-Forwarded-Proto")
+	if proto == "" {
+		return false
+	}
+
+	remoteIP := net.ParseIP(c.RemoteIP())
+	if remoteIP == nil {
+		return false
+	}
+
+	for _, network := range trustedProxies {
+		if network.Contains(remoteIP) {
+			// Proxies may append to the header, the first entry is the client-facing scheme
+			first, _, _ := strings.Cut(proto, ",")
+			return strings.EqualFold(strings.TrimSpace(first), "https")
+		}
+	}
+
+	return false
+}
+
+// parseNetworks accepts IPs and CIDRs, as gin's SetTrustedProxies does
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
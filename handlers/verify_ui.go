@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/shopwarelabs/discord-bot/models"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// verifyStartID is the button of the verification panel
+	verifyStartID = "verify:start"
+	// verifyCheckStatusID re-checks the verification after signing in
+	verifyCheckStatusID = "verify:check-status"
+
+	verifyEmbedColor   = 0x5865F2
+	verifiedEmbedColor = 0x57F287
+
+	// verificationLinkTTL is how long the sign-in link of a prompt can be used
+	verificationLinkTTL = 15 * time.Minute
+)
+
+// verifyPrompt builds the ephemeral sign-in message with a link button, the
+// data notice and a button to check the status afterwards. The link carries a
+// random single-use code issued to the Discord user, so nobody can craft a link
+// that verifies someone else's account. An unexpired code is shown again, so
+// repeated prompts and status checks do not pile up new codes.
+func (h *DiscordHandler) verifyPrompt(config *models.Config, discordID, notice string) (*discordgo.WebhookEdit, error) {
+	code, err := h.verificationCode(discordID)
+	if err != nil {
+		return nil, err
+	}
+
+	verificationURL := fmt.Sprintf("%s/employee/start?code=%s", config.BaseURL, code)
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "Employee verification",
+		Description: renderMessage(config.Messages.VerifyPrompt, "url", verificationURL),
+		Color:       verifyEmbedColor,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "What we store", Value: config.Messages.DataNotice},
+		},
+	}
+	if notice != "" {
+		embed.Footer = &discordgo.MessageEmbedFooter{Text: notice}
+	}
+
+	components := []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{Label: "Sign in with Microsoft", Style: discordgo.LinkButton, URL: verificationURL},
+				discordgo.Button{Label: "Check status", Style: discordgo.SecondaryButton, CustomID: verifyCheckStatusID},
+			},
+		},
+	}
+
+	return embedEdit(embed, components), nil
+}
+
+// verificationCode returns the user's unexpired code or issues a new one
+func (h *DiscordHandler) verificationCode(discordID string) (string, error) {
+	if existing, ok := h.store.GetByDiscordID(discordID); ok {
+		return existing.Code, nil
+	}
+
+	code, err := generateSecureState()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate verification code: %w", err)
+	}
+
+	err = h.store.Store(&models.VerificationCode{
+		Code:      code,
+		DiscordID: discordID,
+		ExpiresAt: time.Now().Add(verificationLinkTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// verifiedStatus builds the message shown to users who are already verified
+func verifiedStatus(config *models.Config, user *models.User) *discordgo.WebhookEdit {
+	return embedEdit(&discordgo.MessageEmbed{
+		Title:       "Verified",
+		Description: config.Messages.AlreadyVerified,
+		Color:       verifiedEmbedColor,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Email", Value: user.Email, Inline: true},
+			{Name: "Verified", Value: fmt.Sprintf("<t:%d:f>", user.VerifiedAt.Unix()), Inline: true},
+		},
+	}, []discordgo.MessageComponent{})
+}
+
+// embedEdit replaces a message with a single embed and the given components
+func embedEdit(embed *discordgo.MessageEmbed, components []discordgo.MessageComponent) *discordgo.WebhookEdit {
+	content := ""
+	return &discordgo.WebhookEdit{
+		Content:    &content,
+		Embeds:     &[]*discordgo.MessageEmbed{embed},
+		Components: &components,
+	}
+}
+
+// grantRolesInGuild gives a verified user the roles of the guild the interaction came
+// from. Verifications are global, so a user verified elsewhere is covered right away.
+func (h *DiscordHandler) grantRolesInGuild(config *models.Config, guildID string, user *models.User) {
+	guild, ok := config.Guild(guildID)
+	if !ok {
+		return
+	}
+	if err := h.grantGuildRoles(guild, user.DiscordID, user.Email); err != nil {
+		slog.Error("Failed to grant roles to verified user", "discord_id", user.DiscordID, "guild_id", guild.ID, "error", err)
+	}
+}
+
+// handleCheckStatus re-queries the store when the user clicks "Check status" and
+// updates the sign-in message in place
+func (h *DiscordHandler) handleCheckStatus(ctx context.Context, s Responder, i *discordgo.InteractionCreate) (*discordgo.WebhookEdit, error) {
+	config := h.currentConfig()
+
+	user, ok := h.store.GetUser(i.Member.User.ID)
+	if !ok {
+		return h.verifyPrompt(config, i.Member.User.ID, "Not verified yet. Sign in with the button above, then check again.")
+	}
+
+	h.grantRolesInGuild(config, i.GuildID, user)
+	return verifiedStatus(config, user), nil
+}
+
+// handleVerifyPanelCommand posts a persistent message with a Verify button, so
+// users can start the verification without knowing the slash command
+func (h *DiscordHandler) handleVerifyPanelCommand(ctx context.Context, s Responder, i *discordgo.InteractionCreate) (*discordgo.WebhookEdit, error) {
+	if !isAdmin(i) {
+		return contentEdit("You need administrator permissions to post the verification panel."), nil
+	}
+
+	config := h.currentConfig()
+
+	channelID := i.ChannelID
+	for _, option := range i.ApplicationCommandData().Options {
+		if option.Name == "channel" {
+			channelID = option.Value.(string)
+		}
+	}
+
+	_, err := h.session.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{
+			{
+				Title:       "Employee verification",
+				Description: config.Messages.VerifyPanel,
+				Color:       verifyEmbedColor,
+				Fields: []*discordgo.MessageEmbedField{
+					{Name: "What we store", Value: config.Messages.DataNotice},
+				},
+			},
+		},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{Label: "Verify", Style: discordgo.PrimaryButton, CustomID: verifyStartID},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to post the verification panel: %w", err)
+	}
+
+	slog.Info("Verification panel posted", "admin_id", i.Member.User.ID, "channel_id", channelID)
+	return contentEdit(fmt.Sprintf("Verification panel posted in <#%s>.", channelID)), nil
+}
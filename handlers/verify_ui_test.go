@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopwarelabs/discord-bot/models"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func promptLink(t *testing.T, prompt *discordgo.WebhookEdit) string {
+	t.Helper()
+
+	row := (*prompt.Components)[0].(discordgo.ActionsRow)
+	return row.Components[0].(discordgo.Button).URL
+}
+
+func TestVerifyPromptReusesUnexpiredCode(t *testing.T) {
+	config := &models.Config{BaseURL: "https://verify.example.com"}
+	store := models.NewMemoryStore()
+	discordHandler, err := NewDiscordHandler(config, store)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	prompt := func() string {
+		prompt, err := discordHandler.verifyPrompt(config, "111", "")
+		if err != nil {
+			t.Fatalf("failed to build prompt: %v", err)
+		}
+		return promptLink(t, prompt)
+	}
+
+	// Checking the status again shows the same link
+	first := prompt()
+	if second := prompt(); second != first {
+		t.Fatalf("second prompt issued a new link %s, want %s", second, first)
+	}
+
+	code, ok := store.GetByDiscordID("111")
+	if !ok {
+		t.Fatal("no code was issued")
+	}
+	if _, ok := store.Consume(code.Code); !ok {
+		t.Fatal("failed to consume code")
+	}
+	if third := prompt(); third == first {
+		t.Fatal("consumed code was shown again")
+	}
+
+	// Expired codes are replaced
+	err = store.Store(&models.VerificationCode{Code: "expired", DiscordID: "222", ExpiresAt: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatalf("failed to store code: %v", err)
+	}
+	expired, err := discordHandler.verifyPrompt(config, "222", "")
+	if err != nil {
+		t.Fatalf("failed to build prompt: %v", err)
+	}
+	if link := promptLink(t, expired); link == "https://verify.example.com/employee/start?code=expired" {
+		t.Fatal("expired code was shown again")
+	}
+}
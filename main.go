@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
@@ -20,77 +23,207 @@ import (
 )
 
 func main() {
+	if err := run(); err != nil {
+		slog.Error("Exiting", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run starts the server or runs the administrative command given on the command
+// line. Errors are returned instead of exiting, so deferred cleanup always runs.
+func run() error {
 	// Load .env file if it exists
 	_ = godotenv.Load()
 
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML configuration file")
+	flag.Usage = func() {
+		_, _ = fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
 	// Load configuration
-	config := models.LoadConfig()
+	config, err := models.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Validate configuration before touching any external resources. Administrative
+	// commands only need the database, the ones talking to Discord check the rest themselves.
+	command := flag.Arg(0)
+	serve := command == "" || command == "serve"
+	validate := config.ValidateStorage
+	if serve {
+		validate = config.Validate
+	}
+	if err := validate(); err != nil {
+		var validationErr *models.ValidationError
+		if errors.As(err, &validationErr) {
+			for _, problem := range validationErr.Problems {
+				slog.Error("Invalid configuration", "problem", problem)
+			}
+		}
+		return err
+	}
 
-	// Validate required configuration
-	if config.MicrosoftClientID == "" || config.MicrosoftClientSecret == "" ||
-		config.DiscordToken == "" || config.DiscordGuildID == "" || config.DiscordRoleID == "" {
-		slog.Error("Missing required configuration", "error", "Please check your environment variables")
+	if serve && !config.IsRelease() && config.SessionSecret == models.DefaultSessionSecret {
+		slog.Warn("Using the default session secret, set SESSION_SECRET before deploying")
 	}
 
 	// Ensure database directory exists
-	if err := os.MkdirAll(filepath.Dir(config.DatabasePath), 0755); err != nil {
-		slog.Error("Failed to create database directory", "error", err)
+	if config.DatabaseURL == "" && config.DatabasePath != models.MemoryDatabasePath {
+		if err := os.MkdirAll(filepath.Dir(config.DatabasePath), 0755); err != nil {
+			return fmt.Errorf("failed to create database directory: %w", err)
+		}
 	}
 
-	// Initialize database
-	db, err := models.NewDatabase(config.DatabasePath)
+	// Initialize database. The migrate and restore commands manage the schema version themselves.
+	openDatabase := models.NewDatabase
+	if command == "migrate" || command == "restore" {
+		openDatabase = models.OpenDatabase
+	}
+	db, err := openDatabase(config.DatabaseDSN())
 	if err != nil {
-		slog.Error("Failed to initialize database", "error", err)
+		return fmt.Errorf("failed to initialize database: %w", err)
 	}
 	defer func() {
 		_ = db.Close()
 	}()
 
-	// Create verification store
-	store := models.NewVerificationStore(db)
+	// Encrypt PII at rest when a data encryption key is configured
+	var cipher *models.FieldCipher
+	if config.DataEncryptionKey != "" {
+		cipher, err = models.NewFieldCipher(config.DataEncryptionKey)
+		if err != nil {
+			return fmt.Errorf("failed to initialize data encryption: %w", err)
+		}
+	}
+
+	// Create verification store. With DATABASE_PATH=:memory: codes, users and audit
+	// events are kept in a MemoryStore, while sessions, OAuth flows and rate limits
+	// use the in-memory SQLite database opened above. Both are pruned by retention.
+	var store models.Storage = models.NewVerificationStore(db, cipher)
+	var memoryStore *models.MemoryStore
+	if config.DatabasePath == models.MemoryDatabasePath && config.DatabaseURL == "" {
+		slog.Warn("Using in-memory storage, all verifications are lost on restart")
+		memoryStore = models.NewMemoryStore()
+		store = memoryStore
+	}
+
+	// Run an administrative command instead of the server if one was given
+	if !serve {
+		if err := runCommand(command, flag.Args()[1:], config, db, store); err != nil {
+			return fmt.Errorf("command %s failed: %w", command, err)
+		}
+		return nil
+	}
+
+	// Prune expired and retained data, compact the database at the configured times
+	db.StartMaintenance(config.Retention)
+	if memoryStore != nil {
+		memoryStore.StartPruning(config.Retention)
+	}
+	if config.DatabasePath != models.MemoryDatabasePath {
+		db.StartBackups(config.Backup)
+	}
 
 	// Initialize handlers
 	discordHandler, err := handlers.NewDiscordHandler(config, store)
 	if err != nil {
-		slog.Error("Failed to create Discord handler", "error", err)
+		return fmt.Errorf("failed to create Discord handler: %w", err)
 	}
 
-	oauthHandler, err := handlers.NewOAuthHandler(config, store, discordHandler)
+	flowStore := models.NewFlowStore(db)
+
+	oauthHandler, err := handlers.NewOAuthHandler(config, store, flowStore, discordHandler)
 	if err != nil {
-		slog.Error("Failed to create OAuth handler", "error", err)
+		return fmt.Errorf("failed to create OAuth handler: %w", err)
+	}
+
+	// Rate limiting, optionally persisted so limits survive restarts
+	var rateLimitStore *models.RateLimitStore
+	if config.RateLimit.Persist {
+		rateLimitStore = models.NewRateLimitStore(db)
+		if memoryStore != nil {
+			memoryStore.SetRateLimitStore(rateLimitStore)
+		}
+	}
+	ipLimiter := handlers.NewPerMinuteRateLimiter("ip", config.RateLimit.IPPerMinute, config.RateLimit.IPBurst, rateLimitStore)
+	discordIDLimiter := handlers.NewPerMinuteRateLimiter(models.RateLimitDiscordID, config.RateLimit.DiscordIDPerMinute, config.RateLimit.DiscordIDBurst, rateLimitStore)
+	callbackLockout := handlers.NewLockout("callback", config.RateLimit.MaxFailedCallbacks, config.RateLimit.LockoutDuration, rateLimitStore)
+	if config.RateLimit.CommandCooldown > 0 {
+		discordHandler.SetCommandCooldown(handlers.NewRateLimiter(models.RateLimitCommand, config.RateLimit.CommandCooldown, 1, rateLimitStore))
 	}
 
+	reloader := newConfigReloader(*configPath, config, discordHandler, oauthHandler)
+	discordHandler.SetConfigReloader(reloader.Reload)
+	discordHandler.SetBackupFunc(func() (string, error) {
+		return db.RunBackup(config.Backup)
+	})
+
 	// Start Discord bot
 	if err := discordHandler.Start(); err != nil {
-		slog.Error("Failed to start Discord bot", "error", err)
+		return fmt.Errorf("failed to start Discord bot: %w", err)
 	}
 	defer func() {
 		_ = discordHandler.Stop()
 	}()
 
-	// Setup Gin router
+	// Setup Gin router, only trusting X-Forwarded-* headers from configured proxies
 	router := gin.Default()
+	if err := router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		return fmt.Errorf("failed to configure trusted proxies: %w", err)
+	}
+
+	securityHeaders, err := handlers.SecurityHeaders(config.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("failed to configure security headers: %w", err)
+	}
+	router.Use(securityHeaders)
 
 	// Setup session store
-	sessionStore := cookie.NewStore([]byte(config.SessionSecret))
+	var sessionStore sessions.Store
+	switch config.SessionStore {
+	case models.SessionStoreCookie:
+		sessionStore = cookie.NewStore(config.SessionKeyPairs()...)
+	default:
+		sessionStore = models.NewSessionStore(db, config.SessionKeyPairs()...)
+	}
 	sessionStore.Options(sessions.Options{
 		Path:     "/",
 		MaxAge:   3600, // 1 hour
 		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
+		Secure:   config.SecureCookies(),
 		SameSite: http.SameSiteLaxMode,
 	})
 	router.Use(sessions.Sessions("discord-session", sessionStore))
 
 	router.LoadHTMLGlob("templates/*")
 
-	router.GET("/employee/start", oauthHandler.StartAuth)
-	router.GET("/employee/callback", oauthHandler.Callback)
+	employee := router.Group("/employee", handlers.RateLimit(ipLimiter, handlers.ClientIPKey))
+	employee.GET("/start", oauthHandler.ResolveVerificationCode, handlers.RateLimit(discordIDLimiter, handlers.DiscordIDKey), oauthHandler.StartAuth)
+	employee.GET("/callback", handlers.FailureLockout(callbackLockout, handlers.ClientIPKey), oauthHandler.Callback)
+
+	// Interactions delivered as signed webhooks instead of over the gateway
+	if config.DiscordPublicKey != "" {
+		router.POST("/discord/interactions", discordHandler.HandleInteractionWebhook)
+	}
+
+	// Admin exports, only enabled when an admin token is configured
+	if config.AdminToken != "" {
+		exportHandler := handlers.NewExportHandler(store)
+		admin := router.Group("/admin", handlers.JSONErrors(), handlers.RateLimit(ipLimiter, handlers.ClientIPKey), handlers.AdminAuth(config.AdminToken))
+		admin.GET("/export/users", exportHandler.ExportUsers)
+		admin.GET("/export/audit-events", exportHandler.ExportAuditEvents)
+	}
 
-	// Health check
+	// Health checks
+	healthHandler := handlers.NewHealthHandler(db, discordHandler, oauthHandler)
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
 	})
+	router.GET("/livez", healthHandler.Livez)
+	router.GET("/readyz", healthHandler.Readyz)
 
 	// Create HTTP server
 	srv := &http.Server{
@@ -106,6 +239,17 @@ func main() {
 		}
 	}()
 
+	// Reload the configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if _, err := reloader.Reload(); err != nil {
+				slog.Error("Failed to reload configuration", "error", err)
+			}
+		}
+	}()
+
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -122,4 +266,5 @@ func main() {
 	}
 
 	slog.Info("Server exited")
+	return nil
 }
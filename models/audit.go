@@ -0,0 +1,195 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Audit event types
+const (
+	AuditVerified           = "verified"
+	AuditVerificationFailed = "verification_failed"
+	AuditDataExported       = "data_exported"
+	AuditDataDeleted        = "data_deleted"
+	AuditRevoked            = "revoked"
+	AuditRoleGrantFailed    = "role_grant_failed"
+)
+
+// AuditEvent records something that happened to a user's verification.
+// Details must not contain personal data so events can be kept after anonymization.
+type AuditEvent struct {
+	ID        int       `json:"id"`
+	EventType string    `json:"event_type"`
+	DiscordID string    `json:"discord_id,omitempty"`
+	Details   string    `json:"details,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserDataExport is everything stored about a single user
+type UserDataExport struct {
+	DiscordID   string       `json:"discord_id"`
+	User        *User        `json:"user,omitempty"`
+	AuditEvents []AuditEvent `json:"audit_events"`
+	ExportedAt  time.Time    `json:"exported_at"`
+}
+
+func (s *VerificationStore) RecordAuditEvent(discordID, eventType, details string) error {
+	query := `
+		INSERT INTO audit_events (event_type, discord_id, details)
+		VALUES (?, ?, ?)
+	`
+
+	_, err := s.db.Exec(query, eventType, nullIfEmpty(discordID), details)
+	if err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+
+	return nil
+}
+
+func (s *VerificationStore) ListAuditEvents(discordID string) ([]AuditEvent, error) {
+	query := `
+		SELECT id, event_type, COALESCE(discord_id, ''), details, created_at
+		FROM audit_events
+		WHERE discord_id = ?
+		ORDER BY created_at, id
+	`
+
+	rows, err := s.db.Query(query, discordID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		if err := rows.Scan(&event.ID, &event.EventType, &event.DiscordID, &event.Details, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// ForEachAuditEvent calls fn for every audit event matching filter, oldest first.
+// Rows are streamed, so fn must not use the store itself.
+func (s *VerificationStore) ForEachAuditEvent(filter AuditFilter, fn func(event *AuditEvent) error) error {
+	query := `
+		SELECT id, event_type, COALESCE(discord_id, ''), details, created_at
+		FROM audit_events
+	`
+	var conditions []string
+	var args []any
+	if filter.EventType != "" {
+		conditions = append(conditions, "event_type = ?")
+		args = append(args, filter.EventType)
+	}
+	if filter.DiscordID != "" {
+		conditions = append(conditions, "discord_id = ?")
+		args = append(args, filter.DiscordID)
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at, id"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var event AuditEvent
+		if err := rows.Scan(&event.ID, &event.EventType, &event.DiscordID, &event.Details, &event.CreatedAt); err != nil {
+			return fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		// Time bounds are compared in Go, SQLite stores CURRENT_TIMESTAMP as text
+		if !filter.Matches(&event) {
+			continue
+		}
+		if err := fn(&event); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// ExportUserData collects the user row and audit events for a Discord ID
+func (s *VerificationStore) ExportUserData(discordID string) (*UserDataExport, error) {
+	events, err := s.ListAuditEvents(discordID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &UserDataExport{
+		DiscordID:   discordID,
+		AuditEvents: events,
+		ExportedAt:  time.Now().UTC(),
+	}
+
+	// Include revoked verifications, they are still personal data
+	query := `
+		SELECT user_id, discord_id, COALESCE(azure_user_id, '') as azure_user_id, email, name, verified_at, created_at, revoked_at
+		FROM users
+		WHERE discord_id = ?
+	`
+
+	user, err := s.scanUser(s.db.QueryRow(query, discordID))
+	switch {
+	case err == nil:
+		export.User = user
+	case err != sql.ErrNoRows:
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+
+	return export, nil
+}
+
+// DeleteUserData removes all personal data for a Discord ID. Existing audit
+// events are detached from the user and an anonymous deletion event is kept.
+func (s *VerificationStore) DeleteUserData(discordID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	statements := []string{
+		`DELETE FROM users WHERE discord_id = ?`,
+		`DELETE FROM verifications WHERE discord_id = ?`,
+		`DELETE FROM oauth_flows WHERE discord_id = ?`,
+		`UPDATE audit_events SET discord_id = NULL WHERE discord_id = ?`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, discordID); err != nil {
+			return fmt.Errorf("failed to delete user data: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM rate_limits WHERE key IN (?, ?)`, userRateLimitKeys(discordID)...); err != nil {
+		return fmt.Errorf("failed to delete user data: %w", err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO audit_events (event_type, details) VALUES (?, ?)`, AuditDataDeleted, "personal data deleted on request")
+	if err != nil {
+		return fmt.Errorf("failed to record deletion: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
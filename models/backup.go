@@ -0,0 +1,268 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupPrefix and backupTimeLayout name scheduled backups so they sort by age
+const (
+	backupPrefix     = "discord-sso-"
+	backupSuffix     = ".db"
+	backupTimeLayout = "20060102T150405Z"
+)
+
+// Backup writes a consistent copy of a SQLite database to path while it stays
+// online and verifies the copy with an integrity check
+func (d *Database) Backup(path string) error {
+	if d.driver != DriverSQLite {
+		return fmt.Errorf("backups are only supported for SQLite, use pg_dump for PostgreSQL")
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup file %s already exists", path)
+	}
+
+	if _, err := d.db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+
+	if _, err := VerifyBackup(path); err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+
+	return nil
+}
+
+// BackupTo writes a timestamped backup into the configured directory and
+// deletes the oldest backups beyond the configured number to keep
+func (d *Database) BackupTo(config BackupConfig) (string, error) {
+	if err := os.MkdirAll(config.Directory, 0750); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	path := filepath.Join(config.Directory, backupPrefix+time.Now().UTC().Format(backupTimeLayout)+backupSuffix)
+	if err := d.Backup(path); err != nil {
+		return "", err
+	}
+
+	if err := rotateBackups(config.Directory, config.Keep); err != nil {
+		return path, err
+	}
+
+	return path, nil
+}
+
+// rotateBackups deletes all but the newest keep scheduled backups in dir
+func rotateBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			backups = append(backups, name)
+		}
+	}
+
+	// Timestamps in the names sort chronologically, newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	for _, name := range backups[min(keep, len(backups)):] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("failed to delete old backup: %w", err)
+		}
+		slog.Info("Deleted old backup", "file", name)
+	}
+
+	return nil
+}
+
+// IntegrityCheck runs PRAGMA integrity_check on the live database
+func (d *Database) IntegrityCheck() error {
+	if d.driver != DriverSQLite {
+		return fmt.Errorf("integrity checks are only supported for SQLite")
+	}
+	return integrityCheck(d.db)
+}
+
+func integrityCheck(db *sql.DB) error {
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("failed to run integrity check: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return fmt.Errorf("failed to read integrity check: %w", err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// VerifyBackup checks a backup file's integrity and returns its schema version.
+// Backups from a newer release than this binary are rejected.
+func VerifyBackup(path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, fmt.Errorf("backup not found: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	if err := integrityCheck(db); err != nil {
+		return 0, fmt.Errorf("backup %s is corrupt: %w", path, err)
+	}
+
+	var version int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("backup %s has no schema version: %w", path, err)
+	}
+
+	switch {
+	case version == 0:
+		return 0, fmt.Errorf("backup %s has no migrations applied", path)
+	case version > LatestSchemaVersion():
+		return 0, fmt.Errorf("backup %s is at schema version %d, newer than the supported %d", path, version, LatestSchemaVersion())
+	}
+
+	return version, nil
+}
+
+// RestoreBackup replaces the SQLite database at dbPath with a verified backup.
+// The server must be stopped. The replaced database is kept next to it with a
+// .pre-restore suffix; older schema versions are migrated on the next start.
+func RestoreBackup(backupPath, dbPath string) error {
+	version, err := VerifyBackup(backupPath)
+	if err != nil {
+		return err
+	}
+
+	staging := dbPath + ".restore"
+	if err := copyFile(backupPath, staging); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		previous := dbPath + ".pre-restore-" + time.Now().UTC().Format(backupTimeLayout)
+		// Move the WAL along with the database, it may hold committed transactions
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			if err := os.Rename(dbPath+suffix, previous+suffix); err != nil && !os.IsNotExist(err) {
+				_ = os.Remove(staging)
+				return fmt.Errorf("failed to move current database aside: %w", err)
+			}
+		}
+		slog.Info("Moved current database aside", "path", previous)
+	}
+
+	if err := os.Rename(staging, dbPath); err != nil {
+		return fmt.Errorf("failed to swap in backup: %w", err)
+	}
+
+	slog.Info("Database restored", "backup", backupPath, "schema_version", version)
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to copy backup: %w", err)
+	}
+
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to sync %s: %w", dst, err)
+	}
+
+	return out.Close()
+}
+
+// StartBackups writes a backup every configured interval. Only SQLite file
+// databases are backed up; a zero interval disables scheduled backups.
+func (d *Database) StartBackups(config BackupConfig) {
+	if config.Interval <= 0 || d.driver != DriverSQLite {
+		return
+	}
+
+	d.statsMu.Lock()
+	d.stats.BackupInterval = config.Interval
+	d.statsMu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if _, err := d.RunBackup(config); err != nil {
+				slog.Error("Scheduled backup failed", "error", err)
+			}
+		}
+	}()
+}
+
+// RunBackup writes a backup like BackupTo and records it in the job statistics
+func (d *Database) RunBackup(config BackupConfig) (string, error) {
+	start := time.Now()
+	path, err := d.BackupTo(config)
+
+	d.statsMu.Lock()
+	d.stats.BackupRuns++
+	if err != nil {
+		d.stats.BackupErrors++
+	} else {
+		d.stats.LastBackup = start
+		d.stats.LastBackupPath = path
+	}
+	d.statsMu.Unlock()
+
+	if err == nil {
+		slog.Info("Database backed up", "path", path, "duration", time.Since(start))
+	}
+
+	return path, err
+}
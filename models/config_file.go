@@ -0,0 +1,143 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig mirrors the layout of the YAML configuration file
+type fileConfig struct {
+	Server struct {
+		Port           string       `yaml:"port"`
+		BaseURL        string       `yaml:"base_url"`
+		SessionSecret  string       `yaml:"session_secret"`
+		SessionStore   string       `yaml:"session_store"`
+		Mode           string       `yaml:"mode"`
+		TrustedProxies []string     `yaml:"trusted_proxies"`
+		SessionKeys    []SessionKey `yaml:"session_keys"`
+		AdminToken     string       `yaml:"admin_token"`
+	} `yaml:"server"`
+
+	Database struct {
+		Path          string `yaml:"path"`
+		URL           string `yaml:"url"`
+		EncryptionKey string `yaml:"encryption_key"`
+	} `yaml:"database"`
+
+	IdentityProviders struct {
+		Microsoft struct {
+			ClientID       string   `yaml:"client_id"`
+			ClientSecret   string   `yaml:"client_secret"`
+			TenantID       string   `yaml:"tenant_id"`
+			AllowedDomains []string `yaml:"allowed_domains"`
+		} `yaml:"microsoft"`
+	} `yaml:"identity_providers"`
+
+	Discord struct {
+		Token     string `yaml:"token"`
+		PublicKey string `yaml:"public_key"`
+		Guild     struct {
+			ID           string `yaml:"id"`
+			RoleID       string `yaml:"role_id"`
+			LogChannelID string `yaml:"log_channel_id"`
+		} `yaml:"guild"`
+		Guilds                   []GuildConfig                             `yaml:"guilds"`
+		CommandLocalizations     map[string]map[string]CommandLocalization `yaml:"command_localizations"`
+		RemoveCommandsOnShutdown *bool                                     `yaml:"remove_commands_on_shutdown"`
+	} `yaml:"discord"`
+
+	RoleRules []RoleRule `yaml:"role_rules"`
+
+	Messages Messages `yaml:"messages"`
+
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+
+	Retention RetentionConfig `yaml:"retention"`
+
+	Backup BackupConfig `yaml:"backup"`
+}
+
+// loadFile applies the values set in the YAML file at path on top of the current configuration
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	// Sections decoded as a whole start from the current values so omitted keys keep their defaults
+	file := fileConfig{RateLimit: c.RateLimit, Retention: c.Retention, Backup: c.Backup}
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	setString(&c.Port, file.Server.Port)
+	setString(&c.BaseURL, file.Server.BaseURL)
+	setString(&c.SessionSecret, file.Server.SessionSecret)
+	setString(&c.SessionStore, file.Server.SessionStore)
+	setString(&c.GinMode, file.Server.Mode)
+	setString(&c.AdminToken, file.Server.AdminToken)
+	setString(&c.DatabasePath, file.Database.Path)
+	setString(&c.DatabaseURL, file.Database.URL)
+	setString(&c.DataEncryptionKey, file.Database.EncryptionKey)
+	if len(file.Server.SessionKeys) > 0 {
+		c.SessionKeys = file.Server.SessionKeys
+	}
+	if len(file.Server.TrustedProxies) > 0 {
+		c.TrustedProxies = file.Server.TrustedProxies
+	}
+
+	microsoft := file.IdentityProviders.Microsoft
+	setString(&c.MicrosoftClientID, microsoft.ClientID)
+	setString(&c.MicrosoftClientSecret, microsoft.ClientSecret)
+	setString(&c.MicrosoftTenantID, microsoft.TenantID)
+	if len(microsoft.AllowedDomains) > 0 {
+		c.AllowedDomains = microsoft.AllowedDomains
+	}
+
+	setString(&c.DiscordToken, file.Discord.Token)
+	setString(&c.DiscordPublicKey, file.Discord.PublicKey)
+	setString(&c.DiscordGuildID, file.Discord.Guild.ID)
+	setString(&c.DiscordRoleID, file.Discord.Guild.RoleID)
+	setString(&c.DiscordLogChannelID, file.Discord.Guild.LogChannelID)
+	if len(file.Discord.Guilds) > 0 {
+		c.Guilds = file.Discord.Guilds
+	}
+	if len(file.Discord.CommandLocalizations) > 0 {
+		c.CommandLocalizations = file.Discord.CommandLocalizations
+	}
+	if file.Discord.RemoveCommandsOnShutdown != nil {
+		c.RemoveCommandsOnShutdown = *file.Discord.RemoveCommandsOnShutdown
+	}
+
+	if len(file.RoleRules) > 0 {
+		c.RoleRules = file.RoleRules
+	}
+
+	setString(&c.Messages.VerifyPrompt, file.Messages.VerifyPrompt)
+	setString(&c.Messages.AlreadyVerified, file.Messages.AlreadyVerified)
+	setString(&c.Messages.VerifiedDM, file.Messages.VerifiedDM)
+	setString(&c.Messages.CommandCooldown, file.Messages.CommandCooldown)
+	setString(&c.Messages.DataNotice, file.Messages.DataNotice)
+	setString(&c.Messages.VerifyPanel, file.Messages.VerifyPanel)
+
+	c.RateLimit = file.RateLimit
+	c.Retention = file.Retention
+	c.Backup = file.Backup
+
+	return nil
+}
+
+func setString(target *string, value string) {
+	if value != "" {
+		*target = value
+	}
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// secretFields are never printed in configuration diffs
+var secretFields = map[string]bool{
+	"MicrosoftClientSecret": true,
+	"DiscordToken":          true,
+	"SessionSecret":         true,
+	"SessionKeys":           true,
+	"DataEncryptionKey":     true,
+	"DatabaseURL":           true,
+	"AdminToken":            true,
+}
+
+// reloadableFields can be swapped at runtime. Everything else is tied to the
+// Discord gateway, the OAuth client, the HTTP listener or the database and
+// only takes effect after a restart.
+var reloadableFields = map[string]bool{
+	"AllowedDomains": true,
+	"RoleRules":      true,
+	"Messages":       true,
+	"Guilds":         true,
+}
+
+// ConfigChange describes a single field that differs between two configurations
+type ConfigChange struct {
+	Field           string
+	Old             string
+	New             string
+	RequiresRestart bool
+}
+
+func (c ConfigChange) String() string {
+	description := fmt.Sprintf("%s: %s -> %s", c.Field, c.Old, c.New)
+	if c.RequiresRestart {
+		description += " (requires restart, ignored)"
+	}
+	return description
+}
+
+// DiffConfig lists the fields that differ between old and new. Secret values are redacted.
+func DiffConfig(old, new *Config) []ConfigChange {
+	var changes []ConfigChange
+
+	oldValue := reflect.ValueOf(old).Elem()
+	newValue := reflect.ValueOf(new).Elem()
+	for _, field := range reflect.VisibleFields(oldValue.Type()) {
+		before := oldValue.FieldByIndex(field.Index).Interface()
+		after := newValue.FieldByIndex(field.Index).Interface()
+		if reflect.DeepEqual(before, after) {
+			continue
+		}
+
+		change := ConfigChange{
+			Field:           field.Name,
+			Old:             fmt.Sprintf("%+v", before),
+			New:             fmt.Sprintf("%+v", after),
+			RequiresRestart: !reloadableFields[field.Name],
+		}
+		if secretFields[field.Name] {
+			change.Old = "[redacted]"
+			change.New = "[redacted]"
+		}
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+// MergeReloadable returns a copy of c with the runtime-reloadable settings taken from next
+func (c *Config) MergeReloadable(next *Config) *Config {
+	merged := *c
+
+	mergedValue := reflect.ValueOf(&merged).Elem()
+	nextValue := reflect.ValueOf(next).Elem()
+	for name := range reloadableFields {
+		mergedValue.FieldByName(name).Set(nextValue.FieldByName(name))
+	}
+
+	return &merged
+}
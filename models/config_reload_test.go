@@ -0,0 +1,31 @@
+package models
+
+import "testing"
+
+func TestReloadGuilds(t *testing.T) {
+	current := &Config{DiscordGuildID: "1", Port: "8080"}
+	next := &Config{
+		DiscordGuildID: "1",
+		Port:           "9090",
+		Guilds:         []GuildConfig{{ID: "2", RoleID: "3", AllowedDomains: []string{"example.com"}}},
+	}
+
+	restart := map[string]bool{}
+	for _, change := range DiffConfig(current, next) {
+		restart[change.Field] = change.RequiresRestart
+	}
+	if requiresRestart, changed := restart["Guilds"]; !changed || requiresRestart {
+		t.Fatalf("Guilds change should be reloadable, got changes %v", restart)
+	}
+	if !restart["Port"] {
+		t.Fatalf("Port change should require a restart, got changes %v", restart)
+	}
+
+	merged := current.MergeReloadable(next)
+	if len(merged.Guilds) != 1 || merged.Guilds[0].ID != "2" {
+		t.Fatalf("guilds were not reloaded: %+v", merged.Guilds)
+	}
+	if merged.Port != "8080" {
+		t.Fatalf("port was reloaded: %s", merged.Port)
+	}
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestValidateOnlyChecksWhatIsNeeded(t *testing.T) {
+	// Defaults without Discord or Microsoft credentials, like an operator running
+	// a maintenance command with only the database configured
+	config := defaultConfig()
+
+	if err := config.ValidateStorage(); err != nil {
+		t.Fatalf("storage settings rejected: %v", err)
+	}
+
+	problems := func(err error) []string {
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Fatalf("got %v, want a ValidationError", err)
+		}
+		return validationErr.Problems
+	}
+
+	discord := problems(config.ValidateDiscord())
+	if !slices.Contains(discord, "DISCORD_TOKEN is required") {
+		t.Fatalf("missing Discord token not reported: %v", discord)
+	}
+	if slices.Contains(discord, "MICROSOFT_CLIENT_SECRET is required") {
+		t.Fatalf("Discord commands require OAuth settings: %v", discord)
+	}
+
+	full := problems(config.Validate())
+	if !slices.Contains(full, "DISCORD_TOKEN is required") || !slices.Contains(full, "MICROSOFT_CLIENT_SECRET is required") {
+		t.Fatalf("server validation is incomplete: %v", full)
+	}
+
+	config.DatabasePath = ""
+	if storage := problems(config.ValidateStorage()); !slices.Contains(storage, "DATABASE_PATH is required") {
+		t.Fatalf("missing database not reported: %v", storage)
+	}
+}
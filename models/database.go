@@ -1,23 +1,64 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	_ "github.com/lib/pq"
 	_ "modernc.org/sqlite"
 )
 
+// Supported database drivers
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
 type Database struct {
-	db *sql.DB
+	db     *sql.DB
+	driver string
+
+	statsMu sync.Mutex
+	stats   JobStats
+}
+
+// NewDatabase opens the database and migrates it to the latest schema version
+func NewDatabase(dsn string) (*Database, error) {
+	database, err := OpenDatabase(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := database.Migrate(); err != nil {
+		_ = database.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return database, nil
 }
 
-func NewDatabase(dbPath string) (*Database, error) {
-	db, err := sql.Open("sqlite", dbPath)
+// OpenDatabase opens the database without running migrations. A postgres:// or
+// postgresql:// DSN selects PostgreSQL, anything else is a SQLite file path.
+func OpenDatabase(dsn string) (*Database, error) {
+	if DatabaseDriver(dsn) == DriverPostgres {
+		return openPostgres(dsn)
+	}
+
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
+	// Every connection to :memory: gets its own empty database, keep a single one
+	if dsn == MemoryDatabasePath {
+		db.SetMaxOpenConns(1)
+	}
+
 	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
 		return nil, fmt.Errorf("failed to enable WAL mode: %w", err)
 	}
@@ -26,87 +67,51 @@ func NewDatabase(dbPath string) (*Database, error) {
 		return nil, fmt.Errorf("failed to enable foreign keys: %w", err)
 	}
 
-	database := &Database{db: db}
+	return &Database{db: db, driver: DriverSQLite}, nil
+}
 
-	if err := database.migrate(); err != nil {
-		return nil, fmt.Errorf("failed to migrate database: %w", err)
+func openPostgres(dsn string) (*Database, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	go database.cleanup()
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
 
-	return database, nil
+	return &Database{db: db, driver: DriverPostgres}, nil
 }
 
-func (d *Database) migrate() error {
-	usersTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		user_id INTEGER PRIMARY KEY AUTOINCREMENT,
-		discord_id TEXT UNIQUE NOT NULL,
-		azure_user_id TEXT UNIQUE NOT NULL,
-		email TEXT NOT NULL,
-		name TEXT NOT NULL,
-		verified_at DATETIME NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-	`
-
-	verificationsTable := `
-	CREATE TABLE IF NOT EXISTS verifications (
-		user_id INTEGER PRIMARY KEY AUTOINCREMENT,
-		code TEXT UNIQUE NOT NULL,
-		discord_id TEXT NOT NULL,
-		email TEXT NOT NULL,
-		expires_at DATETIME NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-	`
-
-	addAzureUserIDColumn := `ALTER TABLE users ADD COLUMN azure_user_id TEXT;`
-
-	indexDiscordID := `CREATE INDEX IF NOT EXISTS idx_users_discord_id ON users(discord_id);`
-	indexAzureUserID := `CREATE INDEX IF NOT EXISTS idx_users_azure_user_id ON users(azure_user_id);`
-	indexVerificationCode := `CREATE INDEX IF NOT EXISTS idx_verifications_code ON verifications(code);`
-	indexVerificationDiscordID := `CREATE INDEX IF NOT EXISTS idx_verifications_discord_id ON verifications(discord_id);`
-	indexVerificationExpires := `CREATE INDEX IF NOT EXISTS idx_verifications_expires_at ON verifications(expires_at);`
-
-	queries := []string{
-		usersTable,
-		verificationsTable,
-		indexDiscordID,
-		indexAzureUserID,
-		indexVerificationCode,
-		indexVerificationDiscordID,
-		indexVerificationExpires,
-	}
-
-	migrationQueries := []string{
-		addAzureUserIDColumn,
-	}
-
-	for _, query := range migrationQueries {
-		_, _ = d.db.Exec(query)
-	}
-
-	for _, query := range queries {
-		if _, err := d.db.Exec(query); err != nil {
-			return fmt.Errorf("failed to execute migration query: %w", err)
-		}
+// DatabaseDriver returns the driver used for a DSN
+func DatabaseDriver(dsn string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return DriverPostgres
 	}
+	return DriverSQLite
+}
 
-	return nil
+// Driver returns the database driver in use
+func (d *Database) Driver() string {
+	return d.driver
 }
 
-func (d *Database) cleanup() {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
+// expiringTables hold rows with an expires_at column that are pruned once expired
+var expiringTables = []string{"verifications", "sessions", "oauth_flows", "rate_limits"}
 
-	for range ticker.C {
-		_, err := d.db.Exec("DELETE FROM verifications WHERE expires_at < ?", time.Now())
-		if err != nil {
-			// Log error but don't stop the cleanup process
-			fmt.Printf("Failed to cleanup expired verifications: %v\n", err)
-		}
+// Ping checks that the database is reachable and can answer a query
+func (d *Database) Ping(ctx context.Context) error {
+	if err := d.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	var count int
+	if err := d.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+		return fmt.Errorf("failed to query database: %w", err)
 	}
+
+	return nil
 }
 
 func (d *Database) Close() error {
@@ -116,3 +121,93 @@ func (d *Database) Close() error {
 func (d *Database) GetDB() *sql.DB {
 	return d.db
 }
+
+// Exec runs a statement written with ? placeholders on any driver
+func (d *Database) Exec(query string, args ...any) (sql.Result, error) {
+	return d.db.Exec(d.rebind(query), utcArgs(args)...)
+}
+
+// Query runs a query written with ? placeholders on any driver
+func (d *Database) Query(query string, args ...any) (*sql.Rows, error) {
+	return d.db.Query(d.rebind(query), utcArgs(args)...)
+}
+
+// QueryRow runs a single row query written with ? placeholders on any driver
+func (d *Database) QueryRow(query string, args ...any) *sql.Row {
+	return d.db.QueryRow(d.rebind(query), utcArgs(args)...)
+}
+
+// Begin starts a transaction whose statements use ? placeholders on any driver
+func (d *Database) Begin() (*Tx, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx: tx, rebind: d.rebind}, nil
+}
+
+// rebind rewrites ? placeholders to the $1, $2 style PostgreSQL expects
+func (d *Database) rebind(query string) string {
+	if d.driver != DriverPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// utcArgs converts time arguments to UTC. SQLite stores times as text, so values
+// written in different zones would not compare correctly with each other or with
+// CURRENT_TIMESTAMP.
+func utcArgs(args []any) []any {
+	converted := make([]any, len(args))
+	for i, arg := range args {
+		switch value := arg.(type) {
+		case time.Time:
+			converted[i] = value.UTC()
+		case *time.Time:
+			if value != nil {
+				converted[i] = value.UTC()
+			}
+		default:
+			converted[i] = arg
+		}
+	}
+	return converted
+}
+
+// Tx is a transaction that rebinds placeholders like Database does
+type Tx struct {
+	tx     *sql.Tx
+	rebind func(string) string
+}
+
+func (t *Tx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.tx.Exec(t.rebind(query), utcArgs(args)...)
+}
+
+func (t *Tx) Query(query string, args ...any) (*sql.Rows, error) {
+	return t.tx.Query(t.rebind(query), utcArgs(args)...)
+}
+
+func (t *Tx) QueryRow(query string, args ...any) *sql.Row {
+	return t.tx.QueryRow(t.rebind(query), utcArgs(args)...)
+}
+
+func (t *Tx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *Tx) Rollback() error {
+	return t.tx.Rollback()
+}
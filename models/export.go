@@ -0,0 +1,213 @@
+package models
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats
+const (
+	ExportFormatCSV    = "csv"
+	ExportFormatJSON   = "json"
+	ExportFormatNDJSON = "ndjson"
+)
+
+// ExportContentType returns the MIME type of an export format
+func ExportContentType(format string) string {
+	switch format {
+	case ExportFormatJSON:
+		return "application/json"
+	case ExportFormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "text/csv"
+	}
+}
+
+// ParseExportFormat validates a format name, an empty name selects CSV
+func ParseExportFormat(format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "", ExportFormatCSV:
+		return ExportFormatCSV, nil
+	case ExportFormatJSON:
+		return ExportFormatJSON, nil
+	case ExportFormatNDJSON, "jsonl":
+		return ExportFormatNDJSON, nil
+	default:
+		return "", fmt.Errorf("unsupported export format %q, expected csv, json or ndjson", format)
+	}
+}
+
+// ParseUserStatus turns active, revoked or all into a user filter
+func ParseUserStatus(status string, filter *UserFilter) error {
+	switch strings.ToLower(status) {
+	case "", "active":
+	case "revoked":
+		filter.RevokedOnly = true
+	case "all":
+		filter.IncludeRevoked = true
+	default:
+		return fmt.Errorf("unsupported status %q, expected active, revoked or all", status)
+	}
+	return nil
+}
+
+// ParseExportRange parses the from and to bounds of an export, each either an
+// RFC 3339 timestamp or a plain UTC date. A plain to date includes the whole day.
+func ParseExportRange(from, to string) (time.Time, time.Time, error) {
+	start, _, err := parseExportTime(from)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	end, dateOnly, err := parseExportTime(to)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if dateOnly {
+		end = end.AddDate(0, 0, 1)
+	}
+	return start, end, nil
+}
+
+func parseExportTime(value string) (time.Time, bool, error) {
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid time %q, expected YYYY-MM-DD or RFC 3339", value)
+	}
+	return t, true, nil
+}
+
+// exportWriter streams records in one of the export formats
+type exportWriter struct {
+	format string
+	w      io.Writer
+	csv    *csv.Writer
+	count  int
+}
+
+func newExportWriter(w io.Writer, format string, header []string) (*exportWriter, error) {
+	e := &exportWriter{format: format, w: w}
+	switch format {
+	case ExportFormatCSV:
+		e.csv = csv.NewWriter(w)
+		if err := e.csv.Write(header); err != nil {
+			return nil, err
+		}
+	case ExportFormatJSON:
+		if _, err := io.WriteString(w, "["); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (e *exportWriter) write(record any, row []string) error {
+	e.count++
+
+	if e.csv != nil {
+		if err := e.csv.Write(row); err != nil {
+			return err
+		}
+		// Flush regularly so large exports stream instead of buffering
+		if e.count%100 == 0 {
+			e.csv.Flush()
+			return e.csv.Error()
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	separator := "\n"
+	if e.format == ExportFormatJSON && e.count > 1 {
+		separator = ",\n"
+	}
+	if e.format == ExportFormatNDJSON {
+		data = append(data, '\n')
+		separator = ""
+	}
+	if _, err := io.WriteString(e.w, separator); err != nil {
+		return err
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *exportWriter) close() error {
+	switch e.format {
+	case ExportFormatCSV:
+		e.csv.Flush()
+		return e.csv.Error()
+	case ExportFormatJSON:
+		_, err := io.WriteString(e.w, "\n]\n")
+		return err
+	}
+	return nil
+}
+
+// ExportUsers streams the users matching filter to w and returns how many were written
+func ExportUsers(w io.Writer, store Storage, format string, filter UserFilter) (int, error) {
+	header := []string{"discord_id", "name", "email", "azure_user_id", "verified_at", "revoked_at"}
+	out, err := newExportWriter(w, format, header)
+	if err != nil {
+		return 0, err
+	}
+
+	err = store.ForEachUser(filter, func(user *User) error {
+		revokedAt := ""
+		if user.RevokedAt != nil {
+			revokedAt = user.RevokedAt.UTC().Format(time.RFC3339)
+		}
+		return out.write(user, []string{
+			user.DiscordID,
+			user.Name,
+			user.Email,
+			user.AzureUserID,
+			user.VerifiedAt.UTC().Format(time.RFC3339),
+			revokedAt,
+		})
+	})
+	if err != nil {
+		return out.count, err
+	}
+
+	return out.count, out.close()
+}
+
+// ExportAuditEvents streams the audit events matching filter to w and returns how many were written
+func ExportAuditEvents(w io.Writer, store Storage, format string, filter AuditFilter) (int, error) {
+	header := []string{"id", "event_type", "discord_id", "details", "created_at"}
+	out, err := newExportWriter(w, format, header)
+	if err != nil {
+		return 0, err
+	}
+
+	err = store.ForEachAuditEvent(filter, func(event *AuditEvent) error {
+		return out.write(event, []string{
+			strconv.Itoa(event.ID),
+			event.EventType,
+			event.DiscordID,
+			event.Details,
+			event.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	})
+	if err != nil {
+		return out.count, err
+	}
+
+	return out.count, out.close()
+}
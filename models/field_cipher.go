@@ -0,0 +1,110 @@
+package models
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// encryptedPrefix marks column values encrypted by FieldCipher, so plaintext
+// rows written before encryption was enabled can still be read
+const encryptedPrefix = "enc:v1:"
+
+// FieldCipher encrypts PII columns with AES-256-GCM and computes keyed hashes
+// that allow equality lookups on encrypted columns
+type FieldCipher struct {
+	aead    cipher.AEAD
+	hashKey []byte
+}
+
+// NewFieldCipher creates a cipher from a hex-encoded 32 byte key.
+// The encryption and hashing keys are derived from it independently.
+func NewFieldCipher(hexKey string) (*FieldCipher, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, fmt.Errorf("data encryption key must be hex encoded: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("data encryption key must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(deriveKey(string(key), "field-encryption"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	return &FieldCipher{
+		aead:    aead,
+		hashKey: deriveKey(string(key), "field-lookup-hash"),
+	}, nil
+}
+
+// Encrypt returns the encrypted, encoded form of plaintext. A nil cipher stores plaintext.
+func (c *FieldCipher) Encrypt(plaintext string) (string, error) {
+	if c == nil || plaintext == "" {
+		return plaintext, nil
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt reverses Encrypt. Values without the encryption prefix are returned unchanged.
+func (c *FieldCipher) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if c == nil {
+		return "", fmt.Errorf("value is encrypted but no data encryption key is configured")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode encrypted value: %w", err)
+	}
+
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", fmt.Errorf("encrypted value is too short")
+	}
+
+	plaintext, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+
+	return string(plaintext), nil
+}
+
+// Hash returns a deterministic keyed hash of value for lookups. Values are
+// compared case-insensitively. A nil cipher returns an empty hash.
+func (c *FieldCipher) Hash(value string) string {
+	if c == nil || value == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, c.hashKey)
+	mac.Write([]byte(strings.ToLower(value)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsEncrypted reports whether a column value was written by FieldCipher
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
@@ -0,0 +1,439 @@
+package models
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryDatabasePath selects the in-memory backend instead of a SQLite file
+const MemoryDatabasePath = ":memory:"
+
+// MemoryStore is a thread-safe Storage kept entirely in memory, for tests and
+// ephemeral deployments. All data is lost when the process exits. It only holds
+// verification codes, users and audit events; with DATABASE_PATH=:memory: sessions,
+// OAuth flows and rate limits live in an in-memory SQLite database next to it.
+type MemoryStore struct {
+	mu sync.RWMutex
+
+	verifications map[string]VerificationCode
+	users         map[string]*User // by Discord ID
+	auditEvents   []AuditEvent
+
+	nextUserID  int
+	nextEventID int
+
+	// rateLimits holds persisted rate limits, which live in the database
+	rateLimits *RateLimitStore
+}
+
+var _ Storage = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		verifications: make(map[string]VerificationCode),
+		users:         make(map[string]*User),
+	}
+}
+
+// SetRateLimitStore lets DeleteUserData remove the persisted rate limits of a user
+func (s *MemoryStore) SetRateLimitStore(rateLimits *RateLimitStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rateLimits = rateLimits
+}
+
+func (s *MemoryStore) Store(code *VerificationCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteExpiredCodes(time.Now())
+
+	if _, exists := s.verifications[code.Code]; exists {
+		return fmt.Errorf("failed to store verification code: code already exists")
+	}
+
+	stored := *code
+	stored.CreatedAt = time.Now()
+	s.verifications[code.Code] = stored
+
+	return nil
+}
+
+func (s *MemoryStore) Get(code string) (*VerificationCode, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	vc, ok := s.verifications[code]
+	if !ok || !vc.ExpiresAt.After(time.Now()) {
+		return nil, false
+	}
+
+	return &vc, true
+}
+
+func (s *MemoryStore) GetByDiscordID(discordID string) (*VerificationCode, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var latest *VerificationCode
+	for _, vc := range s.verifications {
+		if vc.DiscordID != discordID || !vc.ExpiresAt.After(now) {
+			continue
+		}
+		if latest == nil || vc.CreatedAt.After(latest.CreatedAt) {
+			found := vc
+			latest = &found
+		}
+	}
+
+	return latest, latest != nil
+}
+
+func (s *MemoryStore) Consume(code string) (*VerificationCode, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vc, ok := s.verifications[code]
+	if !ok {
+		return nil, false
+	}
+	delete(s.verifications, code)
+
+	if !vc.ExpiresAt.After(time.Now()) {
+		return nil, false
+	}
+
+	return &vc, true
+}
+
+// deleteExpiredCodes drops expired codes, the database backends prune them periodically instead
+func (s *MemoryStore) deleteExpiredCodes(now time.Time) {
+	for code, vc := range s.verifications {
+		if !vc.ExpiresAt.After(now) {
+			delete(s.verifications, code)
+		}
+	}
+}
+
+func (s *MemoryStore) CreateUser(discordID, email, name string) error {
+	return s.CreateUserWithAzureID(discordID, "", email, name)
+}
+
+func (s *MemoryStore) CreateUserWithAzureID(discordID, azureUserID, email, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Revoked rows do not block re-verifying the same Discord or Microsoft account
+	for id, user := range s.users {
+		if user.RevokedAt != nil && (id == discordID || (azureUserID != "" && user.AzureUserID == azureUserID)) {
+			delete(s.users, id)
+		}
+	}
+
+	if _, exists := s.users[discordID]; exists {
+		return fmt.Errorf("failed to create user: discord_id %s already exists", discordID)
+	}
+	if azureUserID != "" {
+		if _, exists := s.findUser(func(user *User) bool { return user.AzureUserID == azureUserID }); exists {
+			return fmt.Errorf("failed to create user: azure_user_id already exists")
+		}
+	}
+
+	s.nextUserID++
+	now := time.Now()
+	s.users[discordID] = &User{
+		UserID:      s.nextUserID,
+		DiscordID:   discordID,
+		AzureUserID: azureUserID,
+		Email:       email,
+		Name:        name,
+		VerifiedAt:  now,
+		CreatedAt:   now,
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) RevokeUser(discordID string) error {
+	s.mu.Lock()
+	user, ok := s.users[discordID]
+	if !ok || user.RevokedAt != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("no verified user with Discord ID %s", discordID)
+	}
+	now := time.Now()
+	user.RevokedAt = &now
+	s.mu.Unlock()
+
+	return s.RecordAuditEvent(discordID, AuditRevoked, "verification revoked")
+}
+
+func (s *MemoryStore) GetUser(discordID string) (*User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[discordID]
+	if !ok || user.RevokedAt != nil {
+		return nil, false
+	}
+
+	return copyUser(user), true
+}
+
+func (s *MemoryStore) GetUserByAzureID(azureUserID string) (*User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.findUser(func(user *User) bool {
+		return user.RevokedAt == nil && user.AzureUserID == azureUserID
+	})
+}
+
+func (s *MemoryStore) GetUserByEmail(email string) (*User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.findUser(func(user *User) bool {
+		return user.RevokedAt == nil && strings.EqualFold(user.Email, email)
+	})
+}
+
+func (s *MemoryStore) IsUserVerified(discordID string) bool {
+	_, exists := s.GetUser(discordID)
+	return exists
+}
+
+func (s *MemoryStore) IsUserVerifiedByAzureID(azureUserID string) bool {
+	_, exists := s.GetUserByAzureID(azureUserID)
+	return exists
+}
+
+// ForEachUser calls fn for every user matching filter, ordered by verification time
+func (s *MemoryStore) ForEachUser(filter UserFilter, fn func(user *User) error) error {
+	s.mu.RLock()
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		if filter.Matches(user) {
+			users = append(users, copyUser(user))
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].VerifiedAt.Equal(users[j].VerifiedAt) {
+			return users[i].UserID < users[j].UserID
+		}
+		return users[i].VerifiedAt.Before(users[j].VerifiedAt)
+	})
+
+	for _, user := range users {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findUser returns a copy of the first user matching, callers must hold the lock
+func (s *MemoryStore) findUser(match func(user *User) bool) (*User, bool) {
+	for _, user := range s.users {
+		if match(user) {
+			return copyUser(user), true
+		}
+	}
+	return nil, false
+}
+
+func copyUser(user *User) *User {
+	copied := *user
+	if user.RevokedAt != nil {
+		revokedAt := *user.RevokedAt
+		copied.RevokedAt = &revokedAt
+	}
+	return &copied
+}
+
+func (s *MemoryStore) RecordAuditEvent(discordID, eventType, details string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recordAuditEvent(discordID, eventType, details)
+	return nil
+}
+
+// recordAuditEvent appends an event, callers must hold the lock
+func (s *MemoryStore) recordAuditEvent(discordID, eventType, details string) {
+	s.nextEventID++
+	s.auditEvents = append(s.auditEvents, AuditEvent{
+		ID:        s.nextEventID,
+		EventType: eventType,
+		DiscordID: discordID,
+		Details:   details,
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+func (s *MemoryStore) ListAuditEvents(discordID string) ([]AuditEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := []AuditEvent{}
+	for _, event := range s.auditEvents {
+		if event.DiscordID == discordID {
+			events = append(events, event)
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].CreatedAt.Before(events[j].CreatedAt)
+	})
+
+	return events, nil
+}
+
+// ForEachAuditEvent calls fn for every audit event matching filter, oldest first
+func (s *MemoryStore) ForEachAuditEvent(filter AuditFilter, fn func(event *AuditEvent) error) error {
+	s.mu.RLock()
+	var events []AuditEvent
+	for _, event := range s.auditEvents {
+		if filter.Matches(&event) {
+			events = append(events, event)
+		}
+	}
+	s.mu.RUnlock()
+
+	for i := range events {
+		if err := fn(&events[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExportUserData collects the user, including a revoked one, and audit events for a Discord ID
+func (s *MemoryStore) ExportUserData(discordID string) (*UserDataExport, error) {
+	events, err := s.ListAuditEvents(discordID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &UserDataExport{
+		DiscordID:   discordID,
+		AuditEvents: events,
+		ExportedAt:  time.Now().UTC(),
+	}
+
+	s.mu.RLock()
+	if user, ok := s.users[discordID]; ok {
+		export.User = copyUser(user)
+	}
+	s.mu.RUnlock()
+
+	return export, nil
+}
+
+// DeleteUserData removes the user, pending codes and persisted rate limits, detaches
+// audit events and records an anonymous deletion event. OAuth flows live in the
+// database and expire on their own.
+func (s *MemoryStore) DeleteUserData(discordID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rateLimits != nil {
+		if err := s.rateLimits.DeleteUser(discordID); err != nil {
+			return err
+		}
+	}
+
+	delete(s.users, discordID)
+
+	for code, vc := range s.verifications {
+		if vc.DiscordID == discordID {
+			delete(s.verifications, code)
+		}
+	}
+
+	for i := range s.auditEvents {
+		if s.auditEvents[i].DiscordID == discordID {
+			s.auditEvents[i].DiscordID = ""
+		}
+	}
+
+	s.recordAuditEvent("", AuditDataDeleted, "personal data deleted on request")
+	return nil
+}
+
+// Prune deletes data past its retention period like Database.Prune and returns
+// the deleted counts per rule
+func (s *MemoryStore) Prune(policy RetentionConfig) map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	deleted := make(map[string]int64)
+
+	codes := len(s.verifications)
+	s.deleteExpiredCodes(now.Add(-policy.ExpiredSessions))
+	deleted["verifications"] = int64(codes - len(s.verifications))
+
+	if policy.RevokedUsers > 0 {
+		cutoff := now.Add(-policy.RevokedUsers)
+		for discordID, user := range s.users {
+			if user.RevokedAt != nil && user.RevokedAt.Before(cutoff) {
+				delete(s.users, discordID)
+				deleted["revoked_users"]++
+			}
+		}
+	}
+
+	// Zero keeps events forever, matching the database rules
+	retention := func(event *AuditEvent) time.Duration {
+		if event.EventType == AuditVerificationFailed {
+			return policy.FailedAttempts
+		}
+		return policy.AuditEvents
+	}
+	kept := s.auditEvents[:0]
+	for _, event := range s.auditEvents {
+		if keep := retention(&event); keep > 0 && event.CreatedAt.Before(now.Add(-keep)) {
+			if event.EventType == AuditVerificationFailed {
+				deleted["failed_attempts"]++
+			} else {
+				deleted["audit_events"]++
+			}
+			continue
+		}
+		kept = append(kept, event)
+	}
+	s.auditEvents = kept
+
+	return deleted
+}
+
+// StartPruning prunes the store every policy.PruneInterval in the background
+func (s *MemoryStore) StartPruning(policy RetentionConfig) {
+	go func() {
+		ticker := time.NewTicker(policy.PruneInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			deleted := s.Prune(policy)
+
+			var total int64
+			for _, count := range deleted {
+				total += count
+			}
+			if total > 0 {
+				slog.Info("Pruned in-memory store", "deleted", deleted)
+			}
+		}
+	}()
+}
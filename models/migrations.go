@@ -0,0 +1,519 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// migrationLockID is the PostgreSQL advisory lock held while migrating
+const migrationLockID = 74219503
+
+// Migration is a numbered schema change. Up and Down run inside a transaction
+// together with the bookkeeping in schema_migrations.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *Tx) error
+	Down    func(tx *Tx) error
+}
+
+// MigrationState reports whether a migration has been applied to the database
+type MigrationState struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// sqliteMigrations is the SQLite schema history and must be ordered by version. Up steps
+// are idempotent so databases created before schema_migrations existed are adopted
+// by replaying them.
+var sqliteMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_users_and_verifications",
+		Up: func(tx *Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS users (
+					user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+					discord_id TEXT UNIQUE NOT NULL,
+					email TEXT NOT NULL,
+					name TEXT NOT NULL,
+					verified_at DATETIME NOT NULL,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`CREATE TABLE IF NOT EXISTS verifications (
+					user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+					code TEXT UNIQUE NOT NULL,
+					discord_id TEXT NOT NULL,
+					email TEXT NOT NULL,
+					expires_at DATETIME NOT NULL,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_users_discord_id ON users(discord_id)`,
+				`CREATE INDEX IF NOT EXISTS idx_verifications_code ON verifications(code)`,
+				`CREATE INDEX IF NOT EXISTS idx_verifications_discord_id ON verifications(discord_id)`,
+				`CREATE INDEX IF NOT EXISTS idx_verifications_expires_at ON verifications(expires_at)`,
+			)
+		},
+		Down: func(tx *Tx) error {
+			return execAll(tx,
+				`DROP TABLE IF EXISTS verifications`,
+				`DROP TABLE IF EXISTS users`,
+			)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_users_azure_user_id",
+		Up: func(tx *Tx) error {
+			if err := addColumn(tx, "users", "azure_user_id", "TEXT"); err != nil {
+				return err
+			}
+			return execAll(tx,
+				`CREATE INDEX IF NOT EXISTS idx_users_azure_user_id ON users(azure_user_id)`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_azure_user_id_unique ON users(azure_user_id)`,
+			)
+		},
+		Down: func(tx *Tx) error {
+			if err := execAll(tx,
+				`DROP INDEX IF EXISTS idx_users_azure_user_id_unique`,
+				`DROP INDEX IF EXISTS idx_users_azure_user_id`,
+			); err != nil {
+				return err
+			}
+			return dropColumn(tx, "users", "azure_user_id")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create_sessions",
+		Up: func(tx *Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS sessions (
+					id TEXT PRIMARY KEY,
+					name TEXT NOT NULL,
+					data TEXT NOT NULL,
+					expires_at DATETIME NOT NULL,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at)`,
+			)
+		},
+		Down: func(tx *Tx) error {
+			return execAll(tx, `DROP TABLE IF EXISTS sessions`)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create_oauth_flows",
+		Up: func(tx *Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS oauth_flows (
+					state TEXT PRIMARY KEY,
+					browser_id TEXT NOT NULL,
+					discord_id TEXT NOT NULL,
+					code_verifier TEXT NOT NULL,
+					nonce TEXT NOT NULL,
+					expires_at DATETIME NOT NULL,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_oauth_flows_expires_at ON oauth_flows(expires_at)`,
+			)
+		},
+		Down: func(tx *Tx) error {
+			return execAll(tx, `DROP TABLE IF EXISTS oauth_flows`)
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create_rate_limits",
+		Up: func(tx *Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS rate_limits (
+					key TEXT PRIMARY KEY,
+					tokens REAL NOT NULL,
+					failures INTEGER NOT NULL DEFAULT 0,
+					locked_until DATETIME NOT NULL,
+					updated_at DATETIME NOT NULL,
+					expires_at DATETIME NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_rate_limits_expires_at ON rate_limits(expires_at)`,
+			)
+		},
+		Down: func(tx *Tx) error {
+			return execAll(tx, `DROP TABLE IF EXISTS rate_limits`)
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_users_lookup_hashes",
+		Up: func(tx *Tx) error {
+			if err := addColumn(tx, "users", "azure_user_id_hash", "TEXT"); err != nil {
+				return err
+			}
+			if err := addColumn(tx, "users", "email_hash", "TEXT"); err != nil {
+				return err
+			}
+			return execAll(tx,
+				`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_azure_user_id_hash ON users(azure_user_id_hash)`,
+				`CREATE INDEX IF NOT EXISTS idx_users_email_hash ON users(email_hash)`,
+			)
+		},
+		Down: func(tx *Tx) error {
+			if err := execAll(tx,
+				`DROP INDEX IF EXISTS idx_users_email_hash`,
+				`DROP INDEX IF EXISTS idx_users_azure_user_id_hash`,
+			); err != nil {
+				return err
+			}
+			if err := dropColumn(tx, "users", "email_hash"); err != nil {
+				return err
+			}
+			return dropColumn(tx, "users", "azure_user_id_hash")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "create_audit_events",
+		Up: func(tx *Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS audit_events (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					event_type TEXT NOT NULL,
+					discord_id TEXT,
+					details TEXT NOT NULL DEFAULT '',
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_audit_events_discord_id ON audit_events(discord_id)`,
+			)
+		},
+		Down: func(tx *Tx) error {
+			return execAll(tx, `DROP TABLE IF EXISTS audit_events`)
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add_retention_columns",
+		Up: func(tx *Tx) error {
+			if err := addColumn(tx, "users", "revoked_at", "DATETIME"); err != nil {
+				return err
+			}
+			return execAll(tx,
+				`CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at)`,
+			)
+		},
+		Down: func(tx *Tx) error {
+			if err := execAll(tx, `DROP INDEX IF EXISTS idx_audit_events_created_at`); err != nil {
+				return err
+			}
+			return dropColumn(tx, "users", "revoked_at")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "rebuild_users_table",
+		// Databases created before schema_migrations declared azure_user_id as
+		// UNIQUE NOT NULL inline, which SQLite can neither relax nor drop with
+		// ALTER TABLE. Rebuilding gives adopted and fresh databases the same table,
+		// so the Down steps of versions 2 to 8 work on both.
+		Up: func(tx *Tx) error {
+			return rebuildUsersTable(tx,
+				`CREATE TABLE users_rebuild (
+					user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+					discord_id TEXT UNIQUE NOT NULL,
+					email TEXT NOT NULL,
+					name TEXT NOT NULL,
+					verified_at DATETIME NOT NULL,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					azure_user_id TEXT,
+					azure_user_id_hash TEXT,
+					email_hash TEXT,
+					revoked_at DATETIME
+				)`,
+			)
+		},
+		// Down rebuilds the table the way versions 1 to 8 create it on a fresh database
+		Down: func(tx *Tx) error {
+			return rebuildUsersTable(tx,
+				`CREATE TABLE users_rebuild (
+					user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+					discord_id TEXT UNIQUE NOT NULL,
+					email TEXT NOT NULL,
+					name TEXT NOT NULL,
+					verified_at DATETIME NOT NULL,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`ALTER TABLE users_rebuild ADD COLUMN azure_user_id TEXT`,
+				`ALTER TABLE users_rebuild ADD COLUMN azure_user_id_hash TEXT`,
+				`ALTER TABLE users_rebuild ADD COLUMN email_hash TEXT`,
+				`ALTER TABLE users_rebuild ADD COLUMN revoked_at DATETIME`,
+			)
+		},
+	},
+}
+
+// LatestSchemaVersion is the version the database is at after all migrations ran.
+// Every driver has a migration for each version.
+func LatestSchemaVersion() int {
+	return sqliteMigrations[len(sqliteMigrations)-1].Version
+}
+
+// migrations returns the schema history for the database driver
+func (d *Database) migrations() []Migration {
+	if d.driver == DriverPostgres {
+		return postgresMigrations
+	}
+	return sqliteMigrations
+}
+
+// Migrate applies all pending migrations
+func (d *Database) Migrate() error {
+	return d.MigrateTo(LatestSchemaVersion())
+}
+
+// MigrateTo applies or rolls back migrations until the database is at target.
+// Each step runs in its own transaction, so a failure leaves the last good version.
+func (d *Database) MigrateTo(target int) error {
+	if target < 0 || target > LatestSchemaVersion() {
+		return fmt.Errorf("unknown schema version %d, latest is %d", target, LatestSchemaVersion())
+	}
+
+	unlock, err := d.lockMigrations()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := d.ensureMigrationsTable(); err != nil {
+		return err
+	}
+
+	current, err := d.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	history := d.migrations()
+	for _, migration := range history {
+		if migration.Version > current && migration.Version <= target {
+			if err := d.applyMigration(migration, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := len(history) - 1; i >= 0; i-- {
+		migration := history[i]
+		if migration.Version <= current && migration.Version > target {
+			if err := d.applyMigration(migration, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the highest applied migration version, 0 for an empty database
+func (d *Database) SchemaVersion() (int, error) {
+	if err := d.ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+
+	var version int
+	if err := d.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	return version, nil
+}
+
+// MigrationStatus lists every known migration and when it was applied
+func (d *Database) MigrationStatus() ([]MigrationState, error) {
+	if err := d.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list applied migrations: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan migration: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	history := d.migrations()
+	states := make([]MigrationState, 0, len(history))
+	for _, migration := range history {
+		state := MigrationState{Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			state.AppliedAt = &appliedAt
+		}
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// lockMigrations serializes migrations of replicas sharing a PostgreSQL database,
+// so only one of them applies each version. SQLite serializes writers itself.
+func (d *Database) lockMigrations() (func(), error) {
+	if d.driver != DriverPostgres {
+		return func() {}, nil
+	}
+
+	// Advisory locks belong to a session, lock and unlock on the same connection
+	ctx := context.Background()
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve connection for the migration lock: %w", err)
+	}
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+
+	return func() {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID); err != nil {
+			slog.Error("Failed to release migration lock", "error", err)
+		}
+		_ = conn.Close()
+	}, nil
+}
+
+func (d *Database) ensureMigrationsTable() error {
+	timestampType := "DATETIME"
+	if d.driver == DriverPostgres {
+		timestampType = "TIMESTAMPTZ"
+	}
+
+	_, err := d.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at ` + timestampType + ` DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Database) applyMigration(migration Migration, up bool) error {
+	direction := "down"
+	if up {
+		direction = "up"
+	}
+
+	tx, err := d.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if up {
+		err = migration.Up(tx)
+		if err == nil {
+			_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, migration.Version, migration.Name)
+		}
+	} else {
+		err = migration.Down(tx)
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, migration.Version)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("migration %d %s (%s) failed: %w", migration.Version, migration.Name, direction, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %w", migration.Version, err)
+	}
+
+	slog.Info("Applied migration", "version", migration.Version, "name", migration.Name, "direction", direction)
+	return nil
+}
+
+// rebuildUsersTable creates users_rebuild with the given statements, copies every
+// user into it and replaces the users table and its indexes with it
+func rebuildUsersTable(tx *Tx, create ...string) error {
+	if err := execAll(tx, create...); err != nil {
+		return err
+	}
+
+	return execAll(tx,
+		`INSERT INTO users_rebuild (user_id, discord_id, email, name, verified_at, created_at,
+			azure_user_id, azure_user_id_hash, email_hash, revoked_at)
+		SELECT user_id, discord_id, email, name, verified_at, created_at,
+			azure_user_id, azure_user_id_hash, email_hash, revoked_at
+		FROM users`,
+		`DROP TABLE users`,
+		`ALTER TABLE users_rebuild RENAME TO users`,
+		`CREATE INDEX idx_users_discord_id ON users(discord_id)`,
+		`CREATE INDEX idx_users_azure_user_id ON users(azure_user_id)`,
+		`CREATE UNIQUE INDEX idx_users_azure_user_id_unique ON users(azure_user_id)`,
+		`CREATE UNIQUE INDEX idx_users_azure_user_id_hash ON users(azure_user_id_hash)`,
+		`CREATE INDEX idx_users_email_hash ON users(email_hash)`,
+	)
+}
+
+func execAll(tx *Tx, queries ...string) error {
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasColumn reports whether a SQLite table already has column, databases migrated
+// by older releases may already contain columns added by later migrations
+func hasColumn(tx *Tx, table, column string) (bool, error) {
+	var count int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	return count > 0, nil
+}
+
+func addColumn(tx *Tx, table, column, definition string) error {
+	exists, err := hasColumn(tx, table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func dropColumn(tx *Tx, table, column string) error {
+	exists, err := hasColumn(tx, table, column)
+	if err != nil || !exists {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column))
+	return err
+}
@@ -0,0 +1,180 @@
+package models
+
+// postgresMigrations mirrors the SQLite schema history version by version
+var postgresMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_users_and_verifications",
+		Up: func(tx *Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS users (
+					user_id BIGSERIAL PRIMARY KEY,
+					discord_id TEXT UNIQUE NOT NULL,
+					email TEXT NOT NULL,
+					name TEXT NOT NULL,
+					verified_at TIMESTAMPTZ NOT NULL,
+					created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`CREATE TABLE IF NOT EXISTS verifications (
+					user_id BIGSERIAL PRIMARY KEY,
+					code TEXT UNIQUE NOT NULL,
+					discord_id TEXT NOT NULL,
+					email TEXT NOT NULL,
+					expires_at TIMESTAMPTZ NOT NULL,
+					created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_users_discord_id ON users(discord_id)`,
+				`CREATE INDEX IF NOT EXISTS idx_verifications_code ON verifications(code)`,
+				`CREATE INDEX IF NOT EXISTS idx_verifications_discord_id ON verifications(discord_id)`,
+				`CREATE INDEX IF NOT EXISTS idx_verifications_expires_at ON verifications(expires_at)`,
+			)
+		},
+		Down: func(tx *Tx) error {
+			return execAll(tx,
+				`DROP TABLE IF EXISTS verifications`,
+				`DROP TABLE IF EXISTS users`,
+			)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_users_azure_user_id",
+		Up: func(tx *Tx) error {
+			return execAll(tx,
+				`ALTER TABLE users ADD COLUMN IF NOT EXISTS azure_user_id TEXT`,
+				`CREATE INDEX IF NOT EXISTS idx_users_azure_user_id ON users(azure_user_id)`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_azure_user_id_unique ON users(azure_user_id)`,
+			)
+		},
+		Down: func(tx *Tx) error {
+			return execAll(tx, `ALTER TABLE users DROP COLUMN IF EXISTS azure_user_id`)
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create_sessions",
+		Up: func(tx *Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS sessions (
+					id TEXT PRIMARY KEY,
+					name TEXT NOT NULL,
+					data TEXT NOT NULL,
+					expires_at TIMESTAMPTZ NOT NULL,
+					created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at)`,
+			)
+		},
+		Down: func(tx *Tx) error {
+			return execAll(tx, `DROP TABLE IF EXISTS sessions`)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create_oauth_flows",
+		Up: func(tx *Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS oauth_flows (
+					state TEXT PRIMARY KEY,
+					browser_id TEXT NOT NULL,
+					discord_id TEXT NOT NULL,
+					code_verifier TEXT NOT NULL,
+					nonce TEXT NOT NULL,
+					expires_at TIMESTAMPTZ NOT NULL,
+					created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_oauth_flows_expires_at ON oauth_flows(expires_at)`,
+			)
+		},
+		Down: func(tx *Tx) error {
+			return execAll(tx, `DROP TABLE IF EXISTS oauth_flows`)
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create_rate_limits",
+		Up: func(tx *Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS rate_limits (
+					key TEXT PRIMARY KEY,
+					tokens DOUBLE PRECISION NOT NULL,
+					failures INTEGER NOT NULL DEFAULT 0,
+					locked_until TIMESTAMPTZ NOT NULL,
+					updated_at TIMESTAMPTZ NOT NULL,
+					expires_at TIMESTAMPTZ NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_rate_limits_expires_at ON rate_limits(expires_at)`,
+			)
+		},
+		Down: func(tx *Tx) error {
+			return execAll(tx, `DROP TABLE IF EXISTS rate_limits`)
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_users_lookup_hashes",
+		Up: func(tx *Tx) error {
+			return execAll(tx,
+				`ALTER TABLE users ADD COLUMN IF NOT EXISTS azure_user_id_hash TEXT`,
+				`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_hash TEXT`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_azure_user_id_hash ON users(azure_user_id_hash)`,
+				`CREATE INDEX IF NOT EXISTS idx_users_email_hash ON users(email_hash)`,
+			)
+		},
+		Down: func(tx *Tx) error {
+			return execAll(tx,
+				`ALTER TABLE users DROP COLUMN IF EXISTS email_hash`,
+				`ALTER TABLE users DROP COLUMN IF EXISTS azure_user_id_hash`,
+			)
+		},
+	},
+	{
+		Version: 7,
+		Name:    "create_audit_events",
+		Up: func(tx *Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS audit_events (
+					id BIGSERIAL PRIMARY KEY,
+					event_type TEXT NOT NULL,
+					discord_id TEXT,
+					details TEXT NOT NULL DEFAULT '',
+					created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_audit_events_discord_id ON audit_events(discord_id)`,
+			)
+		},
+		Down: func(tx *Tx) error {
+			return execAll(tx, `DROP TABLE IF EXISTS audit_events`)
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add_retention_columns",
+		Up: func(tx *Tx) error {
+			return execAll(tx,
+				`ALTER TABLE users ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMPTZ`,
+				`CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at)`,
+			)
+		},
+		Down: func(tx *Tx) error {
+			return execAll(tx,
+				`DROP INDEX IF EXISTS idx_audit_events_created_at`,
+				`ALTER TABLE users DROP COLUMN IF EXISTS revoked_at`,
+			)
+		},
+	},
+	{
+		Version: 9,
+		Name:    "rebuild_users_table",
+		// PostgreSQL databases never had the legacy users table, only make sure
+		// azure_user_id is optional like on SQLite
+		Up: func(tx *Tx) error {
+			return execAll(tx, `ALTER TABLE users ALTER COLUMN azure_user_id DROP NOT NULL`)
+		},
+		// Version 2 already adds azure_user_id as nullable, there is nothing to restore
+		Down: func(tx *Tx) error {
+			return nil
+		},
+	},
+}
@@ -0,0 +1,232 @@
+package models
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// baselineSchema is the schema created by releases before schema_migrations existed
+const baselineSchema = `
+	CREATE TABLE users (
+		user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		discord_id TEXT UNIQUE NOT NULL,
+		azure_user_id TEXT UNIQUE NOT NULL,
+		email TEXT NOT NULL,
+		name TEXT NOT NULL,
+		verified_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE verifications (
+		user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT UNIQUE NOT NULL,
+		discord_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX idx_users_discord_id ON users(discord_id);
+	CREATE INDEX idx_users_azure_user_id ON users(azure_user_id);
+	CREATE INDEX idx_verifications_code ON verifications(code);
+	CREATE INDEX idx_verifications_discord_id ON verifications(discord_id);
+	CREATE INDEX idx_verifications_expires_at ON verifications(expires_at);
+	INSERT INTO users (discord_id, azure_user_id, email, name, verified_at)
+	VALUES ('111', 'azure-111', 'first@example.com', 'First', '2024-01-01 00:00:00');
+`
+
+// preAzureSchema is the schema of releases before users had an Azure ID
+const preAzureSchema = `
+	CREATE TABLE users (
+		user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		discord_id TEXT UNIQUE NOT NULL,
+		email TEXT NOT NULL,
+		name TEXT NOT NULL,
+		verified_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE verifications (
+		user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT UNIQUE NOT NULL,
+		discord_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX idx_users_discord_id ON users(discord_id);
+	INSERT INTO users (discord_id, email, name, verified_at)
+	VALUES ('111', 'first@example.com', 'First', '2024-01-01 00:00:00');
+`
+
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return db
+}
+
+// openLegacyDatabase creates a database with schema but without schema_migrations
+func openLegacyDatabase(t *testing.T, schema string) *Database {
+	t.Helper()
+
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "legacy.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if _, err := db.GetDB().Exec(schema); err != nil {
+		t.Fatalf("failed to create legacy schema: %v", err)
+	}
+
+	return db
+}
+
+func usersTableSQL(t *testing.T, db *Database) string {
+	t.Helper()
+
+	var sql string
+	if err := db.GetDB().QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'users'`).Scan(&sql); err != nil {
+		t.Fatalf("failed to read users schema: %v", err)
+	}
+	return sql
+}
+
+// usersTableShape describes the users columns and indexes, which unlike the stored
+// CREATE statement do not depend on how the table was renamed into place
+func usersTableShape(t *testing.T, db *Database) []string {
+	t.Helper()
+
+	rows, err := db.GetDB().Query(`
+		SELECT 'column ' || name || ' ' || type || ' ' || "notnull" || ' ' || pk FROM pragma_table_info('users')
+		UNION ALL
+		SELECT 'index ' || name || ' ' || "unique" FROM pragma_index_list('users')
+		ORDER BY 1`)
+	if err != nil {
+		t.Fatalf("failed to read users table shape: %v", err)
+	}
+	defer rows.Close()
+
+	var shape []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			t.Fatalf("failed to scan users table shape: %v", err)
+		}
+		shape = append(shape, line)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("failed to read users table shape: %v", err)
+	}
+	return shape
+}
+
+func assertSchemaVersion(t *testing.T, db *Database, want int) {
+	t.Helper()
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatalf("failed to read schema version: %v", err)
+	}
+	if version != want {
+		t.Fatalf("schema version = %d, want %d", version, want)
+	}
+}
+
+func assertUserSurvived(t *testing.T, db *Database) {
+	t.Helper()
+
+	var email, name string
+	if err := db.GetDB().QueryRow(`SELECT email, name FROM users WHERE discord_id = ?`, "111").Scan(&email, &name); err != nil {
+		t.Fatalf("existing user is gone: %v", err)
+	}
+	if email != "first@example.com" || name != "First" {
+		t.Fatalf("existing user changed: %s, %s", email, name)
+	}
+}
+
+func TestMigrateLegacyDatabases(t *testing.T) {
+	fresh := newTestDatabase(t)
+
+	version8, err := OpenDatabase(filepath.Join(t.TempDir(), "version8.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = version8.Close()
+	})
+	if err := version8.MigrateTo(8); err != nil {
+		t.Fatalf("failed to migrate to 8: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{name: "baseline", schema: baselineSchema},
+		{name: "pre azure_user_id", schema: preAzureSchema},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openLegacyDatabase(t, tt.schema)
+
+			if err := db.Migrate(); err != nil {
+				t.Fatalf("migrate failed: %v", err)
+			}
+			assertSchemaVersion(t, db, LatestSchemaVersion())
+			assertUserSurvived(t, db)
+
+			if got, want := usersTableSQL(t, db), usersTableSQL(t, fresh); got != want {
+				t.Fatalf("users table differs from a fresh database:\n%s\nwant:\n%s", got, want)
+			}
+
+			store := NewVerificationStore(db, nil)
+			if err := store.CreateUser("222", "second@example.com", "Second"); err != nil {
+				t.Fatalf("failed to create user without Azure ID: %v", err)
+			}
+
+			if err := db.MigrateTo(8); err != nil {
+				t.Fatalf("migrating down to 8 failed: %v", err)
+			}
+			if got, want := strings.Join(usersTableShape(t, db), "\n"), strings.Join(usersTableShape(t, version8), "\n"); got != want {
+				t.Fatalf("users table at version 8 differs from a fresh database:\n%s\nwant:\n%s", got, want)
+			}
+
+			if err := db.MigrateTo(1); err != nil {
+				t.Fatalf("migrating down to 1 failed: %v", err)
+			}
+			assertSchemaVersion(t, db, 1)
+			assertUserSurvived(t, db)
+
+			if err := db.Migrate(); err != nil {
+				t.Fatalf("migrating back up failed: %v", err)
+			}
+			assertSchemaVersion(t, db, LatestSchemaVersion())
+			assertUserSurvived(t, db)
+		})
+	}
+}
+
+func TestMigrateFreshDatabaseDownAndUp(t *testing.T) {
+	db := newTestDatabase(t)
+	assertSchemaVersion(t, db, LatestSchemaVersion())
+
+	if err := db.MigrateTo(0); err != nil {
+		t.Fatalf("migrating down to 0 failed: %v", err)
+	}
+	assertSchemaVersion(t, db, 0)
+
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("migrating back up failed: %v", err)
+	}
+	assertSchemaVersion(t, db, LatestSchemaVersion())
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// OAuthFlowTTL is how long a started verification flow may take to complete
+const OAuthFlowTTL = 15 * time.Minute
+
+// OAuthFlow is a pending Microsoft sign-in, keyed by its OAuth state parameter
+type OAuthFlow struct {
+	State        string
+	BrowserID    string
+	DiscordID    string
+	CodeVerifier string
+	Nonce        string
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
+
+// FlowStore keeps pending OAuth flows so several can run concurrently in one browser
+type FlowStore struct {
+	db *Database
+}
+
+func NewFlowStore(db *Database) *FlowStore {
+	return &FlowStore{
+		db: db,
+	}
+}
+
+func (s *FlowStore) Create(flow *OAuthFlow) error {
+	query := `
+		INSERT INTO oauth_flows (state, browser_id, discord_id, code_verifier, nonce, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := s.db.Exec(query, flow.State, flow.BrowserID, flow.DiscordID, flow.CodeVerifier, flow.Nonce, flow.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to store OAuth flow: %w", err)
+	}
+
+	return nil
+}
+
+// Consume removes the flow for state and returns it if it has not expired.
+// A flow can only be consumed once, which prevents replaying a callback.
+func (s *FlowStore) Consume(state string) (*OAuthFlow, bool) {
+	query := `
+		DELETE FROM oauth_flows
+		WHERE state = ?
+		RETURNING state, browser_id, discord_id, code_verifier, nonce, expires_at, created_at
+	`
+
+	row := s.db.QueryRow(query, state)
+
+	var flow OAuthFlow
+	err := row.Scan(&flow.State, &flow.BrowserID, &flow.DiscordID, &flow.CodeVerifier, &flow.Nonce, &flow.ExpiresAt, &flow.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false
+		}
+		slog.Error("Failed to consume OAuth flow", "error", err)
+		return nil, false
+	}
+
+	if time.Now().After(flow.ExpiresAt) {
+		return nil, false
+	}
+
+	return &flow, true
+}
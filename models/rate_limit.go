@@ -0,0 +1,101 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Limiters keyed by Discord ID, whose persisted states are personal data
+const (
+	RateLimitDiscordID = "discord_id"
+	RateLimitCommand   = "command"
+)
+
+// userRateLimitKeys returns the persisted rate limit keys of a Discord ID
+func userRateLimitKeys(discordID string) []any {
+	return []any{RateLimitDiscordID + ":" + discordID, RateLimitCommand + ":" + discordID}
+}
+
+// RateLimitState is the persisted state of a token bucket or failure counter
+type RateLimitState struct {
+	Key         string
+	Tokens      float64
+	Failures    int
+	LockedUntil time.Time
+	UpdatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// RateLimitStore persists rate limit state so limits survive restarts
+type RateLimitStore struct {
+	db *Database
+}
+
+func NewRateLimitStore(db *Database) *RateLimitStore {
+	return &RateLimitStore{
+		db: db,
+	}
+}
+
+func (s *RateLimitStore) Get(key string) (*RateLimitState, bool) {
+	query := `
+		SELECT key, tokens, failures, locked_until, updated_at, expires_at
+		FROM rate_limits
+		WHERE key = ? AND expires_at > ?
+	`
+
+	row := s.db.QueryRow(query, key, time.Now())
+
+	var state RateLimitState
+	err := row.Scan(&state.Key, &state.Tokens, &state.Failures, &state.LockedUntil, &state.UpdatedAt, &state.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false
+		}
+		slog.Error("Failed to load rate limit state", "error", err)
+		return nil, false
+	}
+
+	return &state, true
+}
+
+func (s *RateLimitStore) Save(state *RateLimitState) error {
+	query := `
+		INSERT INTO rate_limits (key, tokens, failures, locked_until, updated_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			tokens = excluded.tokens,
+			failures = excluded.failures,
+			locked_until = excluded.locked_until,
+			updated_at = excluded.updated_at,
+			expires_at = excluded.expires_at
+	`
+
+	_, err := s.db.Exec(query, state.Key, state.Tokens, state.Failures, state.LockedUntil, state.UpdatedAt, state.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to store rate limit state: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RateLimitStore) Delete(key string) error {
+	_, err := s.db.Exec(`DELETE FROM rate_limits WHERE key = ?`, key)
+	if err != nil {
+		return fmt.Errorf("failed to delete rate limit state: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteUser removes the rate limit states keyed by a Discord ID
+func (s *RateLimitStore) DeleteUser(discordID string) error {
+	_, err := s.db.Exec(`DELETE FROM rate_limits WHERE key IN (?, ?)`, userRateLimitKeys(discordID)...)
+	if err != nil {
+		return fmt.Errorf("failed to delete rate limit states: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// maintenanceTimeLayout is the format of RetentionConfig.MaintenanceTimes entries
+const maintenanceTimeLayout = "15:04"
+
+// JobStats describes the background pruning, maintenance and backup jobs
+type JobStats struct {
+	PruneInterval     time.Duration    `json:"prune_interval"`
+	LastPrune         time.Time        `json:"last_prune"`
+	LastPruneDeleted  map[string]int64 `json:"last_prune_deleted"`
+	PruneRuns         int64            `json:"prune_runs"`
+	PruneErrors       int64            `json:"prune_errors"`
+	TotalDeleted      int64            `json:"total_deleted"`
+	LastMaintenance   time.Time        `json:"last_maintenance"`
+	MaintenanceRuns   int64            `json:"maintenance_runs"`
+	MaintenanceErrors int64            `json:"maintenance_errors"`
+	BackupInterval    time.Duration    `json:"backup_interval"`
+	LastBackup        time.Time        `json:"last_backup"`
+	LastBackupPath    string           `json:"last_backup_path"`
+	BackupRuns        int64            `json:"backup_runs"`
+	BackupErrors      int64            `json:"backup_errors"`
+}
+
+// pruneRule deletes rows older than a retention cutoff
+type pruneRule struct {
+	name      string
+	query     string
+	retention time.Duration
+	// keepForever skips the rule when retention is zero
+	keepForever bool
+}
+
+func pruneRules(policy RetentionConfig) []pruneRule {
+	rules := []pruneRule{
+		{
+			name:        "audit_events",
+			query:       "DELETE FROM audit_events WHERE event_type != '" + AuditVerificationFailed + "' AND created_at < ?",
+			retention:   policy.AuditEvents,
+			keepForever: true,
+		},
+		{
+			name:        "failed_attempts",
+			query:       "DELETE FROM audit_events WHERE event_type = '" + AuditVerificationFailed + "' AND created_at < ?",
+			retention:   policy.FailedAttempts,
+			keepForever: true,
+		},
+		{
+			name:        "revoked_users",
+			query:       "DELETE FROM users WHERE revoked_at IS NOT NULL AND revoked_at < ?",
+			retention:   policy.RevokedUsers,
+			keepForever: true,
+		},
+	}
+
+	for _, table := range expiringTables {
+		rules = append(rules, pruneRule{
+			name:      table,
+			query:     "DELETE FROM " + table + " WHERE expires_at < ?",
+			retention: policy.ExpiredSessions,
+		})
+	}
+
+	return rules
+}
+
+// Prune deletes data past its retention period and returns the deleted row counts per rule
+func (d *Database) Prune(policy RetentionConfig) (map[string]int64, error) {
+	// created_at defaults to CURRENT_TIMESTAMP and the other timestamps are
+	// written in UTC by the Database wrapper, so compare in UTC as well
+	now := time.Now().UTC()
+	deleted := make(map[string]int64)
+
+	for _, rule := range pruneRules(policy) {
+		if rule.keepForever && rule.retention == 0 {
+			continue
+		}
+
+		result, err := d.Exec(rule.query, now.Add(-rule.retention))
+		if err != nil {
+			return deleted, fmt.Errorf("failed to prune %s: %w", rule.name, err)
+		}
+
+		if affected, err := result.RowsAffected(); err == nil {
+			deleted[rule.name] = affected
+		}
+	}
+
+	return deleted, nil
+}
+
+// RunMaintenance compacts the database file and refreshes query planner statistics
+func (d *Database) RunMaintenance() error {
+	if d.driver == DriverPostgres {
+		if _, err := d.db.Exec("VACUUM ANALYZE"); err != nil {
+			return fmt.Errorf("failed to vacuum database: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := d.db.Exec("PRAGMA optimize"); err != nil {
+		return fmt.Errorf("failed to optimize database: %w", err)
+	}
+
+	if _, err := d.db.Exec("VACUUM"); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+
+	return nil
+}
+
+// StartMaintenance starts the background pruning and maintenance jobs
+func (d *Database) StartMaintenance(policy RetentionConfig) {
+	d.statsMu.Lock()
+	d.stats.PruneInterval = policy.PruneInterval
+	d.stats.LastPrune = time.Now()
+	d.statsMu.Unlock()
+
+	go d.pruneLoop(policy)
+
+	if len(policy.MaintenanceTimes) > 0 {
+		go d.maintenanceLoop(policy.MaintenanceTimes)
+	}
+}
+
+func (d *Database) pruneLoop(policy RetentionConfig) {
+	ticker := time.NewTicker(policy.PruneInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		start := time.Now()
+		deleted, err := d.Prune(policy)
+
+		var total int64
+		for _, count := range deleted {
+			total += count
+		}
+
+		d.statsMu.Lock()
+		d.stats.PruneRuns++
+		if err != nil {
+			d.stats.PruneErrors++
+		} else {
+			d.stats.LastPrune = start
+			d.stats.LastPruneDeleted = deleted
+			d.stats.TotalDeleted += total
+		}
+		d.statsMu.Unlock()
+
+		if err != nil {
+			// Log error but don't stop the pruning job
+			slog.Error("Failed to prune database", "error", err)
+			continue
+		}
+
+		if total > 0 {
+			slog.Info("Pruned database", "deleted", deleted, "duration", time.Since(start))
+		}
+	}
+}
+
+func (d *Database) maintenanceLoop(times []string) {
+	for {
+		next, err := nextMaintenance(times, time.Now())
+		if err != nil {
+			slog.Error("Invalid maintenance schedule, disabling maintenance", "error", err)
+			return
+		}
+
+		time.Sleep(time.Until(next))
+
+		start := time.Now()
+		err = d.RunMaintenance()
+
+		d.statsMu.Lock()
+		d.stats.MaintenanceRuns++
+		if err != nil {
+			d.stats.MaintenanceErrors++
+		} else {
+			d.stats.LastMaintenance = start
+		}
+		d.statsMu.Unlock()
+
+		if err != nil {
+			slog.Error("Database maintenance failed", "error", err)
+			continue
+		}
+
+		slog.Info("Database maintenance completed", "duration", time.Since(start))
+	}
+}
+
+// nextMaintenance returns the next daily occurrence of any of the HH:MM times after now
+func nextMaintenance(times []string, now time.Time) (time.Time, error) {
+	candidates := make([]time.Time, 0, len(times))
+	for _, entry := range times {
+		parsed, err := time.Parse(maintenanceTimeLayout, entry)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid maintenance time %q: %w", entry, err)
+		}
+
+		candidate := time.Date(now.Year(), now.Month(), now.Day(), parsed.Hour(), parsed.Minute(), 0, 0, now.Location())
+		if !candidate.After(now) {
+			candidate = candidate.AddDate(0, 0, 1)
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	if len(candidates) == 0 {
+		return time.Time{}, fmt.Errorf("no maintenance times configured")
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Before(candidates[j])
+	})
+
+	return candidates[0], nil
+}
+
+// JobStats returns a snapshot of the background job statistics
+func (d *Database) JobStats() JobStats {
+	d.statsMu.Lock()
+	defer d.statsMu.Unlock()
+
+	stats := d.stats
+	stats.LastPruneDeleted = make(map[string]int64, len(d.stats.LastPruneDeleted))
+	for name, count := range d.stats.LastPruneDeleted {
+		stats.LastPruneDeleted[name] = count
+	}
+
+	return stats
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// setLocalZone runs the test in a zone west of UTC, where local wall clock times
+// sort before UTC ones
+func setLocalZone(t *testing.T) {
+	t.Helper()
+
+	previous := time.Local
+	time.Local = time.FixedZone("EST", -5*60*60)
+	t.Cleanup(func() {
+		time.Local = previous
+	})
+}
+
+func TestPruneKeepsFreshDataOutsideUTC(t *testing.T) {
+	setLocalZone(t)
+	db := newTestDatabase(t)
+
+	flows := NewFlowStore(db)
+	err := flows.Create(&OAuthFlow{
+		State:        "state",
+		BrowserID:    "browser",
+		DiscordID:    "123",
+		CodeVerifier: "verifier",
+		Nonce:        "nonce",
+		ExpiresAt:    time.Now().Add(OAuthFlowTTL),
+	})
+	if err != nil {
+		t.Fatalf("failed to create flow: %v", err)
+	}
+
+	store := NewVerificationStore(db, nil)
+	if err := store.CreateUser("456", "user@example.com", "User"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if err := store.RevokeUser("456"); err != nil {
+		t.Fatalf("failed to revoke user: %v", err)
+	}
+
+	deleted, err := db.Prune(RetentionConfig{RevokedUsers: time.Hour})
+	if err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+	if deleted["oauth_flows"] != 0 || deleted["revoked_users"] != 0 {
+		t.Fatalf("prune deleted fresh rows: %v", deleted)
+	}
+
+	if _, ok := flows.Consume("state"); !ok {
+		t.Fatal("fresh OAuth flow could not be consumed after pruning")
+	}
+}
+
+func TestPruneDeletesExpiredData(t *testing.T) {
+	setLocalZone(t)
+	db := newTestDatabase(t)
+
+	flows := NewFlowStore(db)
+	err := flows.Create(&OAuthFlow{
+		State:        "state",
+		BrowserID:    "browser",
+		DiscordID:    "123",
+		CodeVerifier: "verifier",
+		Nonce:        "nonce",
+		ExpiresAt:    time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("failed to create flow: %v", err)
+	}
+
+	deleted, err := db.Prune(RetentionConfig{})
+	if err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+	if deleted["oauth_flows"] != 1 {
+		t.Fatalf("expected the expired flow to be pruned, deleted %v", deleted)
+	}
+}
+
+func TestMemoryStorePrune(t *testing.T) {
+	store := NewMemoryStore()
+
+	for _, discordID := range []string{"111", "222"} {
+		if err := store.CreateUser(discordID, discordID+"@example.com", "User"); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		if err := store.RevokeUser(discordID); err != nil {
+			t.Fatalf("failed to revoke user: %v", err)
+		}
+	}
+	if err := store.RecordAuditEvent("333", AuditVerificationFailed, "domain not allowed"); err != nil {
+		t.Fatalf("failed to record audit event: %v", err)
+	}
+	err := store.Store(&VerificationCode{Code: "expired", DiscordID: "444", ExpiresAt: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatalf("failed to store code: %v", err)
+	}
+
+	// Age the first revocation and every audit event past the retention periods
+	old := time.Now().Add(-48 * time.Hour)
+	store.users["111"].RevokedAt = &old
+	for i := range store.auditEvents {
+		if store.auditEvents[i].DiscordID != "222" {
+			store.auditEvents[i].CreatedAt = old
+		}
+	}
+
+	deleted := store.Prune(RetentionConfig{
+		AuditEvents:    24 * time.Hour,
+		FailedAttempts: 24 * time.Hour,
+		RevokedUsers:   24 * time.Hour,
+	})
+	want := map[string]int64{"verifications": 1, "revoked_users": 1, "audit_events": 1, "failed_attempts": 1}
+	for rule, count := range want {
+		if deleted[rule] != count {
+			t.Fatalf("deleted %v, want %v", deleted, want)
+		}
+	}
+
+	export, err := store.ExportUserData("222")
+	if err != nil {
+		t.Fatalf("failed to export user data: %v", err)
+	}
+	if export.User == nil || len(export.AuditEvents) != 1 {
+		t.Fatalf("recent data was pruned: %+v", export)
+	}
+
+	// Zero retention keeps users and events forever
+	if deleted := store.Prune(RetentionConfig{}); deleted["revoked_users"] != 0 || deleted["audit_events"] != 0 {
+		t.Fatalf("zero retention pruned %v", deleted)
+	}
+}
@@ -0,0 +1,227 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+)
+
+// defaultSessionMaxAge is used when no MaxAge is configured for the store
+const defaultSessionMaxAge = 3600
+
+var sessionIDEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+var _ sessions.Store = (*SessionStore)(nil)
+
+// SessionRecord is a stored session as seen by administrative tooling
+type SessionRecord struct {
+	ID        string
+	Name      string
+	Values    map[string]interface{}
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// SessionStore keeps session values in SQLite. The cookie only carries a signed
+// session ID, so sessions can be inspected and revoked server-side.
+type SessionStore struct {
+	db      *Database
+	codecs  []securecookie.Codec
+	options *gsessions.Options
+}
+
+// NewSessionStore creates a session store backed by the sessions table.
+// keyPairs are used to sign the session ID cookie, see securecookie.CodecsFromPairs.
+func NewSessionStore(db *Database, keyPairs ...[]byte) *SessionStore {
+	return &SessionStore{
+		db:     db,
+		codecs: securecookie.CodecsFromPairs(keyPairs...),
+		options: &gsessions.Options{
+			Path:   "/",
+			MaxAge: defaultSessionMaxAge,
+		},
+	}
+}
+
+// Options sets the default cookie options for new sessions
+func (s *SessionStore) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+	for _, codec := range s.codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(s.options.MaxAge)
+		}
+	}
+}
+
+// Get returns a session for the given name after adding it to the registry
+func (s *SessionStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session referenced by the request cookie, or a new one
+func (s *SessionStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	options := *s.options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	if err := securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.codecs...); err != nil {
+		return session, err
+	}
+
+	found, err := s.load(session)
+	if err != nil {
+		return session, err
+	}
+	if !found {
+		// Expired or revoked, start over with a fresh ID
+		session.ID = ""
+		return session, nil
+	}
+
+	session.IsNew = false
+	return session, nil
+}
+
+// Save persists the session and writes the session ID cookie.
+// A MaxAge <= 0 deletes the session.
+func (s *SessionStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if err := s.DeleteSession(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = sessionIDEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+	}
+
+	if err := s.save(session); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return fmt.Errorf("failed to encode session cookie: %w", err)
+	}
+
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func (s *SessionStore) save(session *gsessions.Session) error {
+	values := make(map[string]interface{}, len(session.Values))
+	for key, value := range session.Values {
+		values[fmt.Sprint(key)] = value
+	}
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("failed to encode session values: %w", err)
+	}
+
+	query := `
+		INSERT INTO sessions (id, name, data, expires_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			data = excluded.data,
+			expires_at = excluded.expires_at,
+			updated_at = CURRENT_TIMESTAMP
+	`
+
+	expiresAt := time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second)
+	_, err = s.db.Exec(query, session.ID, session.Name(), string(data), expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to store session: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SessionStore) load(session *gsessions.Session) (bool, error) {
+	query := `
+		SELECT data
+		FROM sessions
+		WHERE id = ? AND name = ? AND expires_at > ?
+	`
+
+	var data string
+	err := s.db.QueryRow(query, session.ID, session.Name(), time.Now()).Scan(&data)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to load session: %w", err)
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &values); err != nil {
+		return false, fmt.Errorf("failed to decode session values: %w", err)
+	}
+
+	for key, value := range values {
+		session.Values[key] = value
+	}
+
+	return true, nil
+}
+
+// DeleteSession revokes a session, e.g. to invalidate a pending verification flow
+func (s *SessionStore) DeleteSession(id string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	return nil
+}
+
+// ListSessions returns all sessions that have not expired yet, newest first
+func (s *SessionStore) ListSessions() ([]SessionRecord, error) {
+	query := `
+		SELECT id, name, data, expires_at, created_at, updated_at
+		FROM sessions
+		WHERE expires_at > ?
+		ORDER BY updated_at DESC
+	`
+
+	rows, err := s.db.Query(query, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var records []SessionRecord
+	for rows.Next() {
+		var record SessionRecord
+		var data string
+		if err := rows.Scan(&record.ID, &record.Name, &data, &record.ExpiresAt, &record.CreatedAt, &record.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		if err := json.Unmarshal([]byte(data), &record.Values); err != nil {
+			return nil, fmt.Errorf("failed to decode session values: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Storage persists pending verification codes, verified users and their audit
+// trail. VerificationStore implements it on top of SQLite or PostgreSQL.
+type Storage interface {
+	// Verification codes
+	Store(code *VerificationCode) error
+	Get(code string) (*VerificationCode, bool)
+	GetByDiscordID(discordID string) (*VerificationCode, bool)
+	Consume(code string) (*VerificationCode, bool)
+
+	// Verified users
+	CreateUser(discordID, email, name string) error
+	CreateUserWithAzureID(discordID, azureUserID, email, name string) error
+	RevokeUser(discordID string) error
+	GetUser(discordID string) (*User, bool)
+	GetUserByAzureID(azureUserID string) (*User, bool)
+	GetUserByEmail(email string) (*User, bool)
+	IsUserVerified(discordID string) bool
+	IsUserVerifiedByAzureID(azureUserID string) bool
+	ForEachUser(filter UserFilter, fn func(user *User) error) error
+
+	// Audit trail and data subject requests
+	RecordAuditEvent(discordID, eventType, details string) error
+	ListAuditEvents(discordID string) ([]AuditEvent, error)
+	ForEachAuditEvent(filter AuditFilter, fn func(event *AuditEvent) error) error
+	ExportUserData(discordID string) (*UserDataExport, error)
+	DeleteUserData(discordID string) error
+}
+
+// UserFilter selects the users passed to ForEachUser. Zero values match everything
+// except revoked verifications.
+type UserFilter struct {
+	// IncludeRevoked also returns revoked verifications
+	IncludeRevoked bool
+	// RevokedOnly returns only revoked verifications
+	RevokedOnly bool
+	// Domain matches the email domain case-insensitively
+	Domain string
+	// VerifiedAfter and VerifiedBefore bound the verification time, inclusive and exclusive
+	VerifiedAfter  time.Time
+	VerifiedBefore time.Time
+}
+
+// Matches reports whether user passes the filter
+func (f UserFilter) Matches(user *User) bool {
+	revoked := user.RevokedAt != nil
+	switch {
+	case f.RevokedOnly && !revoked:
+		return false
+	case revoked && !f.IncludeRevoked && !f.RevokedOnly:
+		return false
+	case f.Domain != "" && !strings.EqualFold(emailDomain(user.Email), f.Domain):
+		return false
+	case !f.VerifiedAfter.IsZero() && user.VerifiedAt.Before(f.VerifiedAfter):
+		return false
+	case !f.VerifiedBefore.IsZero() && !user.VerifiedAt.Before(f.VerifiedBefore):
+		return false
+	}
+	return true
+}
+
+// AuditFilter selects the audit events passed to ForEachAuditEvent. Zero values match everything.
+type AuditFilter struct {
+	EventType string
+	DiscordID string
+	// After and Before bound the event time, inclusive and exclusive
+	After  time.Time
+	Before time.Time
+}
+
+// Matches reports whether event passes the filter
+func (f AuditFilter) Matches(event *AuditEvent) bool {
+	switch {
+	case f.EventType != "" && event.EventType != f.EventType:
+		return false
+	case f.DiscordID != "" && event.DiscordID != f.DiscordID:
+		return false
+	case !f.After.IsZero() && event.CreatedAt.Before(f.After):
+		return false
+	case !f.Before.IsZero() && !event.CreatedAt.Before(f.Before):
+		return false
+	}
+	return true
+}
+
+var _ Storage = (*VerificationStore)(nil)
@@ -0,0 +1,402 @@
+package models
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// storageFactories create an empty store for every Storage implementation. The
+// PostgreSQL store runs against DATABASE_URL and is skipped when it is unset.
+var storageFactories = []struct {
+	name string
+	new  func(t *testing.T) Storage
+}{
+	{
+		name: "sqlite",
+		new: func(t *testing.T) Storage {
+			return NewVerificationStore(newTestDatabase(t), nil)
+		},
+	},
+	{
+		name: "sqlite encrypted",
+		new: func(t *testing.T) Storage {
+			cipher, err := NewFieldCipher("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+			if err != nil {
+				t.Fatalf("failed to create cipher: %v", err)
+			}
+			return NewVerificationStore(newTestDatabase(t), cipher)
+		},
+	},
+	{
+		name: "postgres",
+		new: func(t *testing.T) Storage {
+			dsn := os.Getenv("DATABASE_URL")
+			if dsn == "" {
+				t.Skip("DATABASE_URL is not set")
+			}
+
+			db, err := NewDatabase(dsn)
+			if err != nil {
+				t.Fatalf("failed to open database: %v", err)
+			}
+			t.Cleanup(func() {
+				_ = db.Close()
+			})
+
+			for _, table := range []string{"users", "verifications", "oauth_flows", "audit_events"} {
+				if _, err := db.Exec("DELETE FROM " + table); err != nil {
+					t.Fatalf("failed to empty %s: %v", table, err)
+				}
+			}
+
+			return NewVerificationStore(db, nil)
+		},
+	},
+	{
+		name: "memory",
+		new: func(t *testing.T) Storage {
+			return NewMemoryStore()
+		},
+	},
+}
+
+func TestStorageConformance(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store Storage)
+	}{
+		{name: "verification codes", run: testStorageCodes},
+		{name: "users", run: testStorageUsers},
+		{name: "revoke", run: testStorageRevoke},
+		{name: "user filters", run: testStorageUserFilters},
+		{name: "audit events", run: testStorageAuditEvents},
+		{name: "export and delete user data", run: testStorageUserData},
+	}
+
+	for _, factory := range storageFactories {
+		t.Run(factory.name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					tt.run(t, factory.new(t))
+				})
+			}
+		})
+	}
+}
+
+func testStorageCodes(t *testing.T, store Storage) {
+	err := store.Store(&VerificationCode{
+		Code:      "fresh",
+		DiscordID: "111",
+		Email:     "first@example.com",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("failed to store code: %v", err)
+	}
+	err = store.Store(&VerificationCode{
+		Code:      "expired",
+		DiscordID: "222",
+		Email:     "second@example.com",
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("failed to store code: %v", err)
+	}
+
+	code, ok := store.Get("fresh")
+	if !ok || code.DiscordID != "111" || code.Email != "first@example.com" {
+		t.Fatalf("Get(fresh) = %+v, %v", code, ok)
+	}
+	if code, ok := store.GetByDiscordID("111"); !ok || code.Code != "fresh" {
+		t.Fatalf("GetByDiscordID(111) = %+v, %v", code, ok)
+	}
+	if _, ok := store.Get("expired"); ok {
+		t.Fatal("expired code was returned")
+	}
+	if _, ok := store.GetByDiscordID("222"); ok {
+		t.Fatal("expired code was returned by Discord ID")
+	}
+
+	if code, ok := store.Consume("fresh"); !ok || code.DiscordID != "111" || code.Email != "first@example.com" {
+		t.Fatalf("Consume(fresh) = %+v, %v", code, ok)
+	}
+	if _, ok := store.Get("fresh"); ok {
+		t.Fatal("consumed code was returned")
+	}
+	if _, ok := store.Consume("fresh"); ok {
+		t.Fatal("code was consumed twice")
+	}
+	if _, ok := store.Consume("expired"); ok {
+		t.Fatal("expired code was consumed")
+	}
+}
+
+func testStorageUsers(t *testing.T, store Storage) {
+	if err := store.CreateUserWithAzureID("111", "azure-111", "first@example.com", "First"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if err := store.CreateUser("222", "second@example.com", "Second"); err != nil {
+		t.Fatalf("failed to create user without Azure ID: %v", err)
+	}
+
+	user, ok := store.GetUser("111")
+	if !ok || user.AzureUserID != "azure-111" || user.Email != "first@example.com" || user.Name != "First" {
+		t.Fatalf("GetUser(111) = %+v, %v", user, ok)
+	}
+	if user.VerifiedAt.IsZero() || user.RevokedAt != nil {
+		t.Fatalf("unexpected timestamps: %+v", user)
+	}
+	if user, ok := store.GetUserByAzureID("azure-111"); !ok || user.DiscordID != "111" {
+		t.Fatalf("GetUserByAzureID = %+v, %v", user, ok)
+	}
+	if user, ok := store.GetUserByEmail("second@example.com"); !ok || user.DiscordID != "222" {
+		t.Fatalf("GetUserByEmail = %+v, %v", user, ok)
+	}
+	if !store.IsUserVerified("222") || !store.IsUserVerifiedByAzureID("azure-111") {
+		t.Fatal("created users are not verified")
+	}
+	if store.IsUserVerified("333") || store.IsUserVerifiedByAzureID("azure-333") {
+		t.Fatal("unknown user is verified")
+	}
+
+	if err := store.CreateUser("111", "other@example.com", "Other"); err == nil {
+		t.Fatal("created a second user with the same Discord ID")
+	}
+	if err := store.CreateUserWithAzureID("333", "azure-111", "third@example.com", "Third"); err == nil {
+		t.Fatal("created a second user with the same Azure ID")
+	}
+}
+
+func testStorageRevoke(t *testing.T, store Storage) {
+	if err := store.CreateUserWithAzureID("111", "azure-111", "first@example.com", "First"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	if err := store.RevokeUser("111"); err != nil {
+		t.Fatalf("failed to revoke user: %v", err)
+	}
+	if store.IsUserVerified("111") || store.IsUserVerifiedByAzureID("azure-111") {
+		t.Fatal("revoked user is still verified")
+	}
+	if err := store.RevokeUser("111"); err == nil {
+		t.Fatal("revoking twice succeeded")
+	}
+
+	events, err := store.ListAuditEvents("111")
+	if err != nil {
+		t.Fatalf("failed to list audit events: %v", err)
+	}
+	if len(events) != 1 || events[0].EventType != AuditRevoked {
+		t.Fatalf("unexpected audit events: %+v", events)
+	}
+
+	// A revoked verification does not block verifying the same accounts again
+	if err := store.CreateUserWithAzureID("111", "azure-111", "first@example.com", "First"); err != nil {
+		t.Fatalf("failed to re-verify revoked user: %v", err)
+	}
+	if !store.IsUserVerified("111") {
+		t.Fatal("re-verified user is not verified")
+	}
+}
+
+func testStorageUserFilters(t *testing.T, store Storage) {
+	users := []struct {
+		discordID, email string
+	}{
+		{"111", "first@example.com"},
+		{"222", "second@example.org"},
+		{"333", "third@EXAMPLE.com"},
+	}
+	for _, user := range users {
+		if err := store.CreateUser(user.discordID, user.email, "User"); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+	if err := store.RevokeUser("333"); err != nil {
+		t.Fatalf("failed to revoke user: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter UserFilter
+		want   []string
+	}{
+		{name: "active", filter: UserFilter{}, want: []string{"111", "222"}},
+		{name: "including revoked", filter: UserFilter{IncludeRevoked: true}, want: []string{"111", "222", "333"}},
+		{name: "revoked only", filter: UserFilter{RevokedOnly: true}, want: []string{"333"}},
+		{name: "domain", filter: UserFilter{Domain: "example.com", IncludeRevoked: true}, want: []string{"111", "333"}},
+		{name: "verified before", filter: UserFilter{VerifiedBefore: time.Now().Add(-time.Hour)}, want: nil},
+		{name: "verified after", filter: UserFilter{VerifiedAfter: time.Now().Add(-time.Hour)}, want: []string{"111", "222"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string]bool{}
+			err := store.ForEachUser(tt.filter, func(user *User) error {
+				got[user.DiscordID] = true
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("ForEachUser failed: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got users %v, want %v", got, tt.want)
+			}
+			for _, discordID := range tt.want {
+				if !got[discordID] {
+					t.Fatalf("got users %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func testStorageAuditEvents(t *testing.T, store Storage) {
+	if err := store.RecordAuditEvent("111", AuditVerified, "verified"); err != nil {
+		t.Fatalf("failed to record audit event: %v", err)
+	}
+	if err := store.RecordAuditEvent("111", AuditVerificationFailed, "domain not allowed"); err != nil {
+		t.Fatalf("failed to record audit event: %v", err)
+	}
+	if err := store.RecordAuditEvent("222", AuditVerified, "verified"); err != nil {
+		t.Fatalf("failed to record audit event: %v", err)
+	}
+
+	events, err := store.ListAuditEvents("111")
+	if err != nil {
+		t.Fatalf("failed to list audit events: %v", err)
+	}
+	if len(events) != 2 || events[0].EventType != AuditVerified || events[1].EventType != AuditVerificationFailed {
+		t.Fatalf("unexpected audit events: %+v", events)
+	}
+
+	var verified []string
+	err = store.ForEachAuditEvent(AuditFilter{EventType: AuditVerified}, func(event *AuditEvent) error {
+		verified = append(verified, event.DiscordID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachAuditEvent failed: %v", err)
+	}
+	if len(verified) != 2 {
+		t.Fatalf("got verified events for %v, want 2", verified)
+	}
+
+	var filtered int
+	err = store.ForEachAuditEvent(AuditFilter{DiscordID: "222", After: time.Now().Add(-time.Hour)}, func(event *AuditEvent) error {
+		filtered++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachAuditEvent failed: %v", err)
+	}
+	if filtered != 1 {
+		t.Fatalf("got %d events for 222, want 1", filtered)
+	}
+}
+
+func testStorageUserData(t *testing.T, store Storage) {
+	if err := store.CreateUserWithAzureID("111", "azure-111", "first@example.com", "First"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if err := store.RecordAuditEvent("111", AuditVerified, "verified"); err != nil {
+		t.Fatalf("failed to record audit event: %v", err)
+	}
+	err := store.Store(&VerificationCode{Code: "code", DiscordID: "111", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("failed to store code: %v", err)
+	}
+
+	export, err := store.ExportUserData("111")
+	if err != nil {
+		t.Fatalf("failed to export user data: %v", err)
+	}
+	if export.User == nil || export.User.Email != "first@example.com" || len(export.AuditEvents) != 1 {
+		t.Fatalf("unexpected export: %+v", export)
+	}
+
+	if err := store.DeleteUserData("111"); err != nil {
+		t.Fatalf("failed to delete user data: %v", err)
+	}
+
+	if store.IsUserVerified("111") {
+		t.Fatal("deleted user is still verified")
+	}
+	if _, ok := store.Get("code"); ok {
+		t.Fatal("code of deleted user still exists")
+	}
+
+	export, err = store.ExportUserData("111")
+	if err != nil {
+		t.Fatalf("failed to export user data: %v", err)
+	}
+	if export.User != nil || len(export.AuditEvents) != 0 {
+		t.Fatalf("personal data left after deletion: %+v", export)
+	}
+
+	var deletions int
+	err = store.ForEachAuditEvent(AuditFilter{EventType: AuditDataDeleted}, func(event *AuditEvent) error {
+		deletions++
+		if event.DiscordID != "" {
+			t.Fatalf("deletion event references the user: %+v", event)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachAuditEvent failed: %v", err)
+	}
+	if deletions != 1 {
+		t.Fatalf("got %d deletion events, want 1", deletions)
+	}
+}
+
+func TestDeleteUserDataRemovesRateLimits(t *testing.T) {
+	stores := []struct {
+		name string
+		new  func(rateLimits *RateLimitStore, db *Database) Storage
+	}{
+		{
+			name: "sqlite",
+			new: func(rateLimits *RateLimitStore, db *Database) Storage {
+				return NewVerificationStore(db, nil)
+			},
+		},
+		{
+			name: "memory",
+			new: func(rateLimits *RateLimitStore, db *Database) Storage {
+				store := NewMemoryStore()
+				store.SetRateLimitStore(rateLimits)
+				return store
+			},
+		},
+	}
+
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t)
+			rateLimits := NewRateLimitStore(db)
+			store := tt.new(rateLimits, db)
+
+			keys := []string{RateLimitDiscordID + ":111", RateLimitCommand + ":111", RateLimitCommand + ":222", "ip:111"}
+			for _, key := range keys {
+				err := rateLimits.Save(&RateLimitState{Key: key, Tokens: 1, UpdatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)})
+				if err != nil {
+					t.Fatalf("failed to save rate limit state: %v", err)
+				}
+			}
+
+			if err := store.DeleteUserData("111"); err != nil {
+				t.Fatalf("failed to delete user data: %v", err)
+			}
+
+			for _, key := range keys {
+				_, ok := rateLimits.Get(key)
+				if deleted := key == RateLimitDiscordID+":111" || key == RateLimitCommand+":111"; ok == deleted {
+					t.Fatalf("rate limit %s exists = %v after deleting user 111", key, ok)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Import file formats
+const (
+	ImportFormatCSV  = "csv"
+	ImportFormatJSON = "json"
+)
+
+// ImportRecord is a previously verified employee read from an import file
+type ImportRecord struct {
+	// Row is the 1-based data row in the file, excluding a CSV header
+	Row         int    `json:"row"`
+	DiscordID   string `json:"discord_id"`
+	Email       string `json:"email"`
+	AzureUserID string `json:"azure_user_id,omitempty"`
+	Name        string `json:"name,omitempty"`
+}
+
+// ImportFormatFromName guesses the import format from a file name, defaulting to CSV
+func ImportFormatFromName(name string) string {
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		return ImportFormatJSON
+	}
+	return ImportFormatCSV
+}
+
+// ParseUserImport reads import records. CSV files need a header naming the
+// discord_id and email columns, azure_user_id and name are optional. JSON files
+// hold an array of objects with the same keys.
+func ParseUserImport(r io.Reader, format string) ([]ImportRecord, error) {
+	switch format {
+	case ImportFormatCSV:
+		return parseCSVImport(r)
+	case ImportFormatJSON:
+		return parseJSONImport(r)
+	default:
+		return nil, fmt.Errorf("unsupported import format %q, expected %s or %s", format, ImportFormatCSV, ImportFormatJSON)
+	}
+}
+
+func parseCSVImport(r io.Reader) ([]ImportRecord, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	// Spreadsheet exports often start with a byte order mark
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range []string{"discord_id", "email"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %s column", required)
+		}
+	}
+
+	field := func(row []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	var records []ImportRecord
+	for n := 1; ; n++ {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV row %d: %w", n, err)
+		}
+
+		records = append(records, ImportRecord{
+			Row:         n,
+			DiscordID:   field(row, "discord_id"),
+			Email:       field(row, "email"),
+			AzureUserID: field(row, "azure_user_id"),
+			Name:        field(row, "name"),
+		})
+	}
+
+	return records, nil
+}
+
+func parseJSONImport(r io.Reader) ([]ImportRecord, error) {
+	var records []ImportRecord
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON import: %w", err)
+	}
+
+	for i := range records {
+		records[i].Row = i + 1
+		records[i].DiscordID = strings.TrimSpace(records[i].DiscordID)
+		records[i].Email = strings.TrimSpace(records[i].Email)
+		records[i].AzureUserID = strings.TrimSpace(records[i].AzureUserID)
+		records[i].Name = strings.TrimSpace(records[i].Name)
+	}
+
+	return records, nil
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql"
 	"fmt"
+	"log/slog"
 	"time"
 )
 
@@ -15,32 +16,95 @@ type VerificationCode struct {
 }
 
 type User struct {
-	UserID      int
-	DiscordID   string
-	AzureUserID string
-	Email       string
-	Name        string
-	VerifiedAt  time.Time
-	CreatedAt   time.Time
+	UserID      int        `json:"user_id"`
+	DiscordID   string     `json:"discord_id"`
+	AzureUserID string     `json:"azure_user_id"`
+	Email       string     `json:"email"`
+	Name        string     `json:"name"`
+	VerifiedAt  time.Time  `json:"verified_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
 }
 
 type VerificationStore struct {
-	db *Database
+	db     *Database
+	cipher *FieldCipher
 }
 
-func NewVerificationStore(db *Database) *VerificationStore {
+// NewVerificationStore creates the store. cipher is optional; when set, emails
+// and Azure IDs are encrypted at rest and looked up through keyed hashes.
+func NewVerificationStore(db *Database, cipher *FieldCipher) *VerificationStore {
 	return &VerificationStore{
-		db: db,
+		db:     db,
+		cipher: cipher,
 	}
 }
 
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// nullIfEmpty stores empty lookup hashes as NULL so unique indexes ignore them
+func nullIfEmpty(value string) any {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+func (s *VerificationStore) scanVerificationCode(row rowScanner) (*VerificationCode, error) {
+	var vc VerificationCode
+	if err := row.Scan(&vc.Code, &vc.DiscordID, &vc.Email, &vc.ExpiresAt, &vc.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	email, err := s.cipher.Decrypt(vc.Email)
+	if err != nil {
+		return nil, err
+	}
+	vc.Email = email
+
+	return &vc, nil
+}
+
+func (s *VerificationStore) scanUser(row rowScanner) (*User, error) {
+	var user User
+	var revokedAt sql.NullTime
+	if err := row.Scan(&user.UserID, &user.DiscordID, &user.AzureUserID, &user.Email, &user.Name, &user.VerifiedAt, &user.CreatedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		user.RevokedAt = &revokedAt.Time
+	}
+
+	azureUserID, err := s.cipher.Decrypt(user.AzureUserID)
+	if err != nil {
+		return nil, err
+	}
+	user.AzureUserID = azureUserID
+
+	email, err := s.cipher.Decrypt(user.Email)
+	if err != nil {
+		return nil, err
+	}
+	user.Email = email
+
+	return &user, nil
+}
+
 func (s *VerificationStore) Store(code *VerificationCode) error {
 	query := `
 		INSERT INTO verifications (code, discord_id, email, expires_at)
 		VALUES (?, ?, ?, ?)
 	`
 
-	_, err := s.db.GetDB().Exec(query, code.Code, code.DiscordID, code.Email, code.ExpiresAt)
+	email, err := s.cipher.Encrypt(code.Email)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt email: %w", err)
+	}
+
+	_, err = s.db.Exec(query, code.Code, code.DiscordID, email, code.ExpiresAt)
 	if err != nil {
 		return fmt.Errorf("failed to store verification code: %w", err)
 	}
@@ -55,10 +119,9 @@ func (s *VerificationStore) Get(code string) (*VerificationCode, bool) {
 		WHERE code = ? AND expires_at > ?
 	`
 
-	row := s.db.GetDB().QueryRow(query, code, time.Now())
+	row := s.db.QueryRow(query, code, time.Now())
 
-	var vc VerificationCode
-	err := row.Scan(&vc.Code, &vc.DiscordID, &vc.Email, &vc.ExpiresAt, &vc.CreatedAt)
+	vc, err := s.scanVerificationCode(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, false
@@ -68,7 +131,7 @@ func (s *VerificationStore) Get(code string) (*VerificationCode, bool) {
 		return nil, false
 	}
 
-	return &vc, true
+	return vc, true
 }
 
 func (s *VerificationStore) GetByDiscordID(discordID string) (*VerificationCode, bool) {
@@ -80,10 +143,9 @@ func (s *VerificationStore) GetByDiscordID(discordID string) (*VerificationCode,
 		LIMIT 1
 	`
 
-	row := s.db.GetDB().QueryRow(query, discordID, time.Now())
+	row := s.db.QueryRow(query, discordID, time.Now())
 
-	var vc VerificationCode
-	err := row.Scan(&vc.Code, &vc.DiscordID, &vc.Email, &vc.ExpiresAt, &vc.CreatedAt)
+	vc, err := s.scanVerificationCode(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, false
@@ -92,27 +154,52 @@ func (s *VerificationStore) GetByDiscordID(discordID string) (*VerificationCode,
 		return nil, false
 	}
 
-	return &vc, true
+	return vc, true
 }
 
-func (s *VerificationStore) Delete(code string) error {
-	query := `DELETE FROM verifications WHERE code = ?`
+// Consume removes the code and returns it if it has not expired. The single
+// DELETE makes sure concurrent requests cannot both use the same code.
+func (s *VerificationStore) Consume(code string) (*VerificationCode, bool) {
+	query := `
+		DELETE FROM verifications
+		WHERE code = ?
+		RETURNING code, discord_id, email, expires_at, created_at
+	`
+
+	row := s.db.QueryRow(query, code)
 
-	_, err := s.db.GetDB().Exec(query, code)
+	vc, err := s.scanVerificationCode(row)
 	if err != nil {
-		return fmt.Errorf("failed to delete verification code: %w", err)
+		if err == sql.ErrNoRows {
+			return nil, false
+		}
+		slog.Error("Failed to consume verification code", "error", err)
+		return nil, false
 	}
 
-	return nil
+	if !vc.ExpiresAt.After(time.Now()) {
+		return nil, false
+	}
+
+	return vc, true
 }
 
 func (s *VerificationStore) CreateUser(discordID, email, name string) error {
 	query := `
-		INSERT INTO users (discord_id, email, name, verified_at)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO users (discord_id, email, email_hash, name, verified_at)
+		VALUES (?, ?, ?, ?, ?)
 	`
 
-	_, err := s.db.GetDB().Exec(query, discordID, email, name, time.Now())
+	encryptedEmail, err := s.cipher.Encrypt(email)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt email: %w", err)
+	}
+
+	if err := s.deleteRevokedUser(discordID, ""); err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(query, discordID, encryptedEmail, nullIfEmpty(s.cipher.Hash(email)), name, time.Now())
 	if err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
 	}
@@ -122,11 +209,26 @@ func (s *VerificationStore) CreateUser(discordID, email, name string) error {
 
 func (s *VerificationStore) CreateUserWithAzureID(discordID, azureUserID, email, name string) error {
 	query := `
-		INSERT INTO users (discord_id, azure_user_id, email, name, verified_at)
-		VALUES (?, ?, ?, ?, ?)
+		INSERT INTO users (discord_id, azure_user_id, azure_user_id_hash, email, email_hash, name, verified_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := s.db.GetDB().Exec(query, discordID, azureUserID, email, name, time.Now())
+	encryptedAzureUserID, err := s.cipher.Encrypt(azureUserID)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt Azure ID: %w", err)
+	}
+
+	encryptedEmail, err := s.cipher.Encrypt(email)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt email: %w", err)
+	}
+
+	if err := s.deleteRevokedUser(discordID, azureUserID); err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(query, discordID, encryptedAzureUserID, nullIfEmpty(s.cipher.Hash(azureUserID)),
+		encryptedEmail, nullIfEmpty(s.cipher.Hash(email)), name, time.Now())
 	if err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
 	}
@@ -134,17 +236,46 @@ func (s *VerificationStore) CreateUserWithAzureID(discordID, azureUserID, email,
 	return nil
 }
 
+// deleteRevokedUser removes revoked rows that would block re-verifying the same Discord or Microsoft account
+func (s *VerificationStore) deleteRevokedUser(discordID, azureUserID string) error {
+	query := `
+		DELETE FROM users
+		WHERE revoked_at IS NOT NULL AND (discord_id = ? OR azure_user_id_hash = ? OR azure_user_id = ?)
+	`
+
+	_, err := s.db.Exec(query, discordID, s.cipher.Hash(azureUserID), nullIfEmpty(azureUserID))
+	if err != nil {
+		return fmt.Errorf("failed to delete revoked user: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeUser marks a verification as revoked. The row is kept until the revoked
+// users retention period has passed.
+func (s *VerificationStore) RevokeUser(discordID string) error {
+	result, err := s.db.Exec(`UPDATE users SET revoked_at = ? WHERE discord_id = ? AND revoked_at IS NULL`, time.Now(), discordID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke user: %w", err)
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("no verified user with Discord ID %s", discordID)
+	}
+
+	return s.RecordAuditEvent(discordID, AuditRevoked, "verification revoked")
+}
+
 func (s *VerificationStore) GetUser(discordID string) (*User, bool) {
 	query := `
-		SELECT user_id, discord_id, COALESCE(azure_user_id, '') as azure_user_id, email, name, verified_at, created_at
+		SELECT user_id, discord_id, COALESCE(azure_user_id, '') as azure_user_id, email, name, verified_at, created_at, revoked_at
 		FROM users
-		WHERE discord_id = ?
+		WHERE discord_id = ? AND revoked_at IS NULL
 	`
 
-	row := s.db.GetDB().QueryRow(query, discordID)
+	row := s.db.QueryRow(query, discordID)
 
-	var user User
-	err := row.Scan(&user.UserID, &user.DiscordID, &user.AzureUserID, &user.Email, &user.Name, &user.VerifiedAt, &user.CreatedAt)
+	user, err := s.scanUser(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, false
@@ -153,20 +284,20 @@ func (s *VerificationStore) GetUser(discordID string) (*User, bool) {
 		return nil, false
 	}
 
-	return &user, true
+	return user, true
 }
 
 func (s *VerificationStore) GetUserByAzureID(azureUserID string) (*User, bool) {
+	// Rows written before encryption was enabled still hold the plaintext value
 	query := `
-		SELECT user_id, discord_id, azure_user_id, email, name, verified_at, created_at
+		SELECT user_id, discord_id, azure_user_id, email, name, verified_at, created_at, revoked_at
 		FROM users
-		WHERE azure_user_id = ?
+		WHERE (azure_user_id_hash = ? OR azure_user_id = ?) AND revoked_at IS NULL
 	`
 
-	row := s.db.GetDB().QueryRow(query, azureUserID)
+	row := s.db.QueryRow(query, s.cipher.Hash(azureUserID), azureUserID)
 
-	var user User
-	err := row.Scan(&user.UserID, &user.DiscordID, &user.AzureUserID, &user.Email, &user.Name, &user.VerifiedAt, &user.CreatedAt)
+	user, err := s.scanUser(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, false
@@ -176,7 +307,68 @@ func (s *VerificationStore) GetUserByAzureID(azureUserID string) (*User, bool) {
 		return nil, false
 	}
 
-	return &user, true
+	return user, true
+}
+
+func (s *VerificationStore) GetUserByEmail(email string) (*User, bool) {
+	query := `
+		SELECT user_id, discord_id, COALESCE(azure_user_id, '') as azure_user_id, email, name, verified_at, created_at, revoked_at
+		FROM users
+		WHERE (email_hash = ? OR LOWER(email) = LOWER(?)) AND revoked_at IS NULL
+	`
+
+	row := s.db.QueryRow(query, s.cipher.Hash(email), email)
+
+	user, err := s.scanUser(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false
+		}
+		slog.Error("Failed to get user by email", "error", err)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// ForEachUser calls fn for every user matching filter, ordered by verification time.
+// Rows are streamed, so fn must not use the store itself. Emails may be encrypted,
+// so everything but the revocation status is filtered after decrypting.
+func (s *VerificationStore) ForEachUser(filter UserFilter, fn func(user *User) error) error {
+	query := `
+		SELECT user_id, discord_id, COALESCE(azure_user_id, '') as azure_user_id, email, name, verified_at, created_at, revoked_at
+		FROM users
+	`
+	switch {
+	case filter.RevokedOnly:
+		query += ` WHERE revoked_at IS NOT NULL`
+	case !filter.IncludeRevoked:
+		query += ` WHERE revoked_at IS NULL`
+	}
+	query += ` ORDER BY verified_at, user_id`
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		user, err := s.scanUser(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan user: %w", err)
+		}
+		if !filter.Matches(user) {
+			continue
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
 }
 
 func (s *VerificationStore) IsUserVerifiedByAzureID(azureUserID string) bool {
@@ -188,3 +380,82 @@ func (s *VerificationStore) IsUserVerified(discordID string) bool {
 	_, exists := s.GetUser(discordID)
 	return exists
 }
+
+// EncryptExistingUsers encrypts plaintext emails and Azure IDs written before
+// encryption was enabled and fills in their lookup hashes. It returns the number
+// of rows updated and is safe to run repeatedly.
+func (s *VerificationStore) EncryptExistingUsers() (int, error) {
+	if s.cipher == nil {
+		return 0, fmt.Errorf("no data encryption key configured")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rows, err := tx.Query(`SELECT user_id, COALESCE(azure_user_id, ''), email FROM users`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query users: %w", err)
+	}
+
+	type plaintextRow struct {
+		userID      int
+		azureUserID string
+		email       string
+	}
+
+	var pending []plaintextRow
+	for rows.Next() {
+		var row plaintextRow
+		if err := rows.Scan(&row.userID, &row.azureUserID, &row.email); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("failed to scan user: %w", err)
+		}
+		if (row.azureUserID != "" && !IsEncrypted(row.azureUserID)) || !IsEncrypted(row.email) {
+			pending = append(pending, row)
+		}
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to iterate users: %w", err)
+	}
+
+	for _, row := range pending {
+		azureUserID, err := s.cipher.Decrypt(row.azureUserID)
+		if err != nil {
+			return 0, err
+		}
+		email, err := s.cipher.Decrypt(row.email)
+		if err != nil {
+			return 0, err
+		}
+
+		encryptedAzureUserID, err := s.cipher.Encrypt(azureUserID)
+		if err != nil {
+			return 0, err
+		}
+		encryptedEmail, err := s.cipher.Encrypt(email)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.Exec(`
+			UPDATE users
+			SET azure_user_id = ?, azure_user_id_hash = ?, email = ?, email_hash = ?
+			WHERE user_id = ?
+		`, nullIfEmpty(encryptedAzureUserID), nullIfEmpty(s.cipher.Hash(azureUserID)), encryptedEmail, s.cipher.Hash(email), row.userID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt user %d: %w", row.userID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return len(pending), nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/shopwarelabs/discord-bot/handlers"
+	"github.com/shopwarelabs/discord-bot/models"
+)
+
+// configReloader re-reads the configuration and swaps the runtime-reloadable
+// settings into the handlers without touching the gateway or OAuth client
+type configReloader struct {
+	path           string
+	discordHandler *handlers.DiscordHandler
+	oauthHandler   *handlers.OAuthHandler
+
+	mu      sync.Mutex
+	current *models.Config
+}
+
+func newConfigReloader(path string, current *models.Config, discordHandler *handlers.DiscordHandler, oauthHandler *handlers.OAuthHandler) *configReloader {
+	return &configReloader{
+		path:           path,
+		discordHandler: discordHandler,
+		oauthHandler:   oauthHandler,
+		current:        current,
+	}
+}
+
+// Reload loads and validates the configuration, applies it and returns what changed.
+// The running configuration is left untouched if loading or validation fails.
+func (r *configReloader) Reload() ([]models.ConfigChange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := models.LoadConfig(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+
+	changes := models.DiffConfig(r.current, next)
+	for _, change := range changes {
+		if change.RequiresRestart {
+			slog.Warn("Configuration change requires a restart", "field", change.Field)
+			continue
+		}
+		slog.Info("Configuration changed", "field", change.Field, "old", change.Old, "new", change.New)
+	}
+
+	merged := r.current.MergeReloadable(next)
+	r.discordHandler.ApplyConfig(merged)
+	r.oauthHandler.ApplyConfig(merged)
+	r.current = merged
+
+	// Guilds added by the reload need the slash commands
+	for _, change := range changes {
+		if change.Field == "Guilds" {
+			if _, err := r.discordHandler.SyncCommands(false); err != nil {
+				slog.Error("Failed to sync slash commands after reload", "error", err)
+			}
+			break
+		}
+	}
+
+	slog.Info("Configuration reloaded", "changes", len(changes))
+	return changes, nil
+}